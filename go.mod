@@ -9,6 +9,8 @@ require (
 	github.com/lrstanley/girc v0.0.0-20250219025855-423afa8a8828
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/viper v1.20.1
+	golang.org/x/crypto v0.37.0
+	golang.org/x/net v0.39.0
 )
 
 require (
@@ -0,0 +1,116 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Command is an IRC command users can send to CptHook, e.g. "!alerts #monitoring"
+type Command struct {
+	Name string
+	// Usage describes the arguments, e.g. "[#channel]"
+	Usage string
+	Help  string
+	// MinArgs and MaxArgs limit the number of arguments. MaxArgs is -1 for no limit.
+	MinArgs int
+	MaxArgs int
+	// Channel returns the channel whose data the command shows, if any. The access
+	// control checks the sender against this channel instead of the one the command was
+	// sent in.
+	Channel func(ctx CommandContext) string
+	// Run executes the command and returns the lines of the reply. The error is shown
+	// to the user.
+	Run func(ctx CommandContext) ([]string, error)
+}
+
+// CommandContext describes a single invocation of a command
+type CommandContext struct {
+	Args    []string
+	Nick    string
+	Account string
+	// Channel is the channel the command was sent in, empty in a query
+	Channel string
+}
+
+// Commander is implemented by modules which provide IRC commands
+type Commander interface {
+	Commands() []Command
+}
+
+// ParseArguments splits a command line at whitespace. Arguments containing whitespace
+// can be quoted with double quotes, a backslash escapes the next character.
+func ParseArguments(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg, quoted, escaped := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inArg, escaped = true, true
+		case r == '"':
+			inArg, quoted = true, !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			inArg = true
+			current.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("missing closing quote")
+	}
+	if escaped {
+		return nil, fmt.Errorf("line ends with a backslash")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// statusCommand creates a command which shows the problems of a channel tracked by Status.
+// The channel is the first argument, the channel the command was sent in or defaultChannel.
+func statusCommand(name, help, defaultChannel string, describe func(StatusSummary) string) Command {
+	target := func(ctx CommandContext) string {
+		if len(ctx.Args) > 0 {
+			return ctx.Args[0]
+		}
+		if ctx.Channel != "" {
+			return ctx.Channel
+		}
+		return defaultChannel
+	}
+	return Command{
+		Name:    name,
+		Usage:   "[#channel]",
+		Help:    help,
+		MaxArgs: 1,
+		Channel: target,
+		Run: func(ctx CommandContext) ([]string, error) {
+			channel := target(ctx)
+			if channel == "" {
+				return nil, fmt.Errorf("please name a channel")
+			}
+			return []string{fmt.Sprintf("\x02%s\x02: %s", channel, describe(Status.Summary(channel)))}, nil
+		},
+	}
+}
+
+// listNames joins names, but only shows the first few of them
+func listNames(names []string) string {
+	const max = 10
+	if len(names) <= max {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:max], ", "), len(names)-max)
+}
@@ -0,0 +1,73 @@
+package input
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArguments(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"alerts", []string{"alerts"}},
+		{"  alerts   #infra ", []string{"alerts", "#infra"}},
+		{`ack web1 "disk is full"`, []string{"ack", "web1", "disk is full"}},
+		{`say \"quoted\" a\ b ""`, []string{"say", `"quoted"`, "a b", ""}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		got, err := ParseArguments(test.line)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parsed %q wrong: got %q wanted %q", test.line, got, test.want)
+		}
+	}
+
+	for _, line := range []string{`ack "web1`, `ack web1\`} {
+		if _, err := ParseArguments(line); err == nil {
+			t.Errorf("Parsing %q didn't fail", line)
+		}
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	m := PrometheusModule{defaultChannel: "#prometheus"}
+	alerts := m.Commands()[0]
+
+	Status.set("#prometheus", statusEntry{Kind: kindAlert, Name: "HighLoad"})
+	Status.set("#prometheus", statusEntry{Kind: kindAlert, Name: "DiskFull"})
+	defer Status.clear("#prometheus", kindAlert, "HighLoad")
+	defer Status.clear("#prometheus", kindAlert, "DiskFull")
+
+	tests := []struct {
+		ctx     CommandContext
+		channel string
+		want    string
+	}{
+		// In a query the default channel of the module is used
+		{CommandContext{}, "#prometheus", "\x02#prometheus\x02: 2 alerts firing: DiskFull, HighLoad"},
+		{CommandContext{Channel: "#other"}, "#other", "\x02#other\x02: No alerts firing"},
+		{CommandContext{Channel: "#other", Args: []string{"#Prometheus"}}, "#Prometheus", "\x02#Prometheus\x02: 2 alerts firing: DiskFull, HighLoad"},
+	}
+	for _, test := range tests {
+		// The access control checks the channel which is read
+		if channel := alerts.Channel(test.ctx); channel != test.channel {
+			t.Errorf("Command reads channel %q, wanted %q", channel, test.channel)
+		}
+		lines, err := alerts.Run(test.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != 1 || lines[0] != test.want {
+			t.Errorf("Command returned %q, wanted %q", lines, test.want)
+		}
+	}
+
+	if _, err := (Icinga2Module{}).Commands()[0].Run(CommandContext{}); err == nil {
+		t.Error("Command without channel didn't fail")
+	}
+}
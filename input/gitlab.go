@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
 	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -17,8 +21,24 @@ type GitlabModule struct {
 	channelMapping mapping
 	channel        chan IRCMessage
 	commitLimit    int
+	// notifyAssignees sends merge requests to their assignees by query
+	notifyAssignees bool
+	// users maps Gitlab usernames to IRC nicknames or services accounts
+	users map[string]string
+	// apiToken is used to fetch the logs of failed jobs, which aren't part of the webhook
+	apiToken string
+	apiURL   string
 }
 
+// jobLogClient fetches job logs from the Gitlab API
+var jobLogClient = &http.Client{Timeout: 10 * time.Second}
+
+// jobLogTail is the number of bytes at the end of a job log which are kept
+const jobLogTail = 1024 * 1024
+
+// jobLogMarkup matches the terminal colors and the collapsible sections in job logs
+var jobLogMarkup = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]|section_(start|end):[0-9]+:[^\r\n]*\r`)
+
 type mapping struct {
 	DefaultChannel   string              `mapstructure:"default_channel"`
 	GroupMappings    map[string][]string `mapstructure:"groups"`
@@ -68,6 +88,15 @@ func (m *GitlabModule) Init(c *viper.Viper, channel *chan IRCMessage) {
 
 	m.channel = *channel
 
+	m.apiToken = c.GetString("api_token")
+	m.apiURL = strings.TrimSuffix(c.GetString("api_url"), "/")
+
+	m.notifyAssignees = c.GetBool("notify_assignees")
+	err = c.UnmarshalKey("users", &m.users)
+	if err != nil {
+		log.Fatal("Failed to unmarshal user mapping into map")
+	}
+
 	if c.IsSet("commit_limit") {
 		commitLimit := c.GetInt("commit_limit")
 		if 0 < commitLimit && commitLimit <= 20 {
@@ -82,7 +111,7 @@ func (m *GitlabModule) Init(c *viper.Viper, channel *chan IRCMessage) {
 
 }
 
-func (m GitlabModule) sendMessage(message string, projectName string, pathWithNamespace string) {
+func (m GitlabModule) getChannels(pathWithNamespace string) []string {
 	var channelNames []string
 
 	if list := contains(m.channelMapping.ExplicitMappings, pathWithNamespace); len(list) > 0 { // Check if explizit mapping exists
@@ -96,10 +125,12 @@ func (m GitlabModule) sendMessage(message string, projectName string, pathWithNa
 	} else { // Fall back to default channel
 		channelNames = append(channelNames, m.channelMapping.DefaultChannel)
 	}
+	return channelNames
+}
 
-	for _, channelName := range channelNames {
-		var event IRCMessage
-		event.Messages = append(event.Messages, message)
+func (m GitlabModule) sendMessage(event IRCMessage, pathWithNamespace string) {
+	event.Module = "Gitlab"
+	for _, channelName := range m.getChannels(pathWithNamespace) {
 		event.Channel = channelName
 		event.generateID()
 		log.WithFields(log.Fields{
@@ -111,6 +142,78 @@ func (m GitlabModule) sendMessage(message string, projectName string, pathWithNa
 
 }
 
+// sendDirect sends the message by query to the IRC user of a Gitlab user. Users without
+// mapping are expected to use their Gitlab username on IRC.
+func (m GitlabModule) sendDirect(event IRCMessage, username string) {
+	name, ok := m.users[strings.ToLower(username)]
+	if !ok {
+		name = username
+	}
+	event.Module = "Gitlab"
+	event.Channel = UserTargetPrefix + name
+	event.generateID()
+	log.WithFields(log.Fields{
+		"MsgID":  event.ID,
+		"Module": "Gitlab",
+		"user":   name,
+	}).Info("Dispatching message to IRC handler")
+	m.channel <- event
+}
+
+// jobLog returns the log of a job without colors. The API is found at the Gitlab instance
+// of the project homepage, unless api_url is configured.
+func (m GitlabModule) jobLog(homepage string, projectID, jobID int) (string, error) {
+	base := m.apiURL
+	if base == "" {
+		u, err := url.Parse(homepage)
+		if err != nil {
+			return "", err
+		}
+		base = u.Scheme + "://" + u.Host + "/api/v4"
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/projects/%d/jobs/%d/trace", base, projectID, jobID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("PRIVATE-TOKEN", m.apiToken)
+	resp, err := jobLogClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gitlab returned %s", resp.Status)
+	}
+	data, err := readTail(resp.Body, jobLogTail)
+	if err != nil {
+		return "", err
+	}
+	return jobLogMarkup.ReplaceAllString(string(data), ""), nil
+}
+
+// readTail reads r completely, but only keeps the last n bytes
+func readTail(r io.Reader, n int) ([]byte, error) {
+	chunk := make([]byte, 32*1024)
+	buf := make([]byte, 0, 2*n+len(chunk))
+	for {
+		// Only move the tail to the front when the buffer is full
+		if len(buf)+len(chunk) > cap(buf) {
+			buf = append(buf[:0], buf[len(buf)-n:]...)
+		}
+		read, err := r.Read(chunk)
+		buf = append(buf, chunk[:read]...)
+		if err == io.EOF {
+			if len(buf) > n {
+				buf = buf[len(buf)-n:]
+			}
+			return buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func (m GitlabModule) GetChannelList() []string {
 	var all []string
 
@@ -129,18 +232,30 @@ func (m GitlabModule) GetChannelList() []string {
 	return all
 }
 
+// Commands returns the IRC commands of the module
+func (m GitlabModule) Commands() []Command {
+	return []Command{
+		statusCommand("pipelines", "Shows the failed pipelines of a channel", m.channelMapping.DefaultChannel, func(s StatusSummary) string {
+			if s.PipelinesFailed == 0 {
+				return "No failed pipelines"
+			}
+			return fmt.Sprintf("%d pipelines failed: %s", s.PipelinesFailed, listNames(s.Pipelines))
+		}),
+	}
+}
+
 func (m GitlabModule) GetHandler() http.HandlerFunc {
 
-	const pushCompareString = "[\x0312{{ .Project.Name }}\x03] {{ .UserName }} pushed {{ .TotalCommits }} commits to \x0305{{ .Branch }}\x03 {{ .Project.WebURL }}/compare/{{ .BeforeCommit }}...{{ .AfterCommit }}"
-	const pushCommitLogString = "[\x0312{{ .Project.Name }}\x03] {{ .UserName }} pushed {{ .TotalCommits }} commits to \x0305{{ .Branch }}\x03 {{ .Project.WebURL }}/commits/{{ .Branch }}"
+	const pushCompareString = "[\x0312{{ .Project.Name }}\x03] {{ .UserName }} pushed {{ .TotalCommits }} commits to \x0305{{ .Branch }}\x03 {{ shorten (printf \"%s/compare/%s...%s\" .Project.WebURL .BeforeCommit .AfterCommit) }}"
+	const pushCommitLogString = "[\x0312{{ .Project.Name }}\x03] {{ .UserName }} pushed {{ .TotalCommits }} commits to \x0305{{ .Branch }}\x03 {{ shorten (printf \"%s/commits/%s\" .Project.WebURL .Branch) }}"
 	const branchCreateString = "[\x0312{{ .Project.Name }}\x03] {{ .UserName }} created the branch \x0305{{ .Branch }}\x03"
 	const branchDeleteString = "[\x0312{{ .Project.Name }}\x03] {{ .UserName }} deleted the branch \x0305{{ .Branch }}\x03"
-	const commitString = "\x0315{{ .ShortID }}\x03 (\x0303+{{ .AddedFiles }}\x03|\x0308±{{ .ModifiedFiles }}\x03|\x0304-{{ .RemovedFiles }}\x03) \x0306{{ .Author.Name }}\x03: {{ .Title }}"
-	const issueString = "[\x0312{{ .Project.Name }}\x03] {{ .User.Name }} {{ .Issue.Action }} issue \x0308#{{ .Issue.Iid }}\x03: {{ .Issue.Title }} {{ .Issue.URL }}"
-	const mergeString = "[\x0312{{ .Project.Name }}\x03] {{ .User.Name }} {{ .Merge.Action }} merge request \x0308#{{ .Merge.Iid }}\x03: {{ .Merge.Title }} {{ .Merge.URL }}"
-	const pipelineCreateString = "[\x0312{{ .Project.Name }}\x03] Pipeline for commit {{ .Pipeline.Commit }} {{ .Pipeline.Status }} {{ .Project.WebURL }}/pipelines/{{ .Pipeline.ID }}"
-	const pipelineCompleteString = "[\x0312{{ .Project.Name }}\x03] Pipeline for commit {{ .Pipeline.Commit }} {{ .Pipeline.Status }} in {{ .Pipeline.Duration }} seconds {{ .Project.WebURL }}/pipelines/{{ .Pipeline.ID }}"
-	const jobCompleteString = "[\x0312{{ .Repository.Name }}\x03] Job \x0308{{ .Name }}\x03 for commit {{ .Commit }} {{ .Status }} in {{ .Duration }} seconds {{ .Repository.Homepage }}/-/jobs/{{ .ID }}"
+	const commitString = "\x0315{{ .ShortID }}\x03 (\x0303+{{ .AddedFiles }}\x03|\x0308±{{ .ModifiedFiles }}\x03|\x0304-{{ .RemovedFiles }}\x03) \x0306{{ .Author.Name }}\x03: {{ markdown .Title }}{{ with paste .Message }} {{ . }}{{ end }}"
+	const issueString = "[\x0312{{ .Project.Name }}\x03] {{ .User.Name }} {{ .Issue.Action }} issue \x0308#{{ .Issue.Iid }}\x03: {{ markdown .Issue.Title }} {{ shorten .Issue.URL }}"
+	const mergeString = "[\x0312{{ .Project.Name }}\x03] {{ .User.Name }} {{ .Merge.Action }} merge request \x0308#{{ .Merge.Iid }}\x03: {{ markdown .Merge.Title }} {{ shorten .Merge.URL }}"
+	const pipelineCreateString = "[\x0312{{ .Project.Name }}\x03] Pipeline for commit {{ .Pipeline.Commit }} {{ .Pipeline.Status }} {{ shorten (printf \"%s/pipelines/%v\" .Project.WebURL .Pipeline.ID) }}"
+	const pipelineCompleteString = "[\x0312{{ .Project.Name }}\x03] Pipeline for commit {{ .Pipeline.Commit }} {{ .Pipeline.Status }} in {{ .Pipeline.Duration }} seconds {{ shorten (printf \"%s/pipelines/%v\" .Project.WebURL .Pipeline.ID) }}"
+	const jobCompleteString = "[\x0312{{ .Repository.Name }}\x03] Job \x0308{{ .Name }}\x03 for commit {{ .Commit }} {{ .Status }} in {{ .Duration }} seconds {{ shorten (printf \"%s/-/jobs/%v\" .Repository.Homepage .ID) }}{{ with .LogPaste }} {{ . }}{{ end }}"
 
 	JobStatus := map[string]string{
 		"pending": "is \x0315pending\x03",
@@ -160,16 +275,16 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 
 	const NullCommit = "0000000000000000000000000000000000000000"
 
-	pushCompareTemplate := template.Must(template.New("push notification").Parse(pushCompareString))
-	pushCommitLogTemplate := template.Must(template.New("push to new branch notification").Parse(pushCommitLogString))
-	branchCreateTemplate := template.Must(template.New("branch creat notification").Parse(branchCreateString))
-	branchDeleteTemplate := template.Must(template.New("branch delete notification").Parse(branchDeleteString))
-	commitTemplate := template.Must(template.New("commit notification").Parse(commitString))
-	issueTemplate := template.Must(template.New("issue notification").Parse(issueString))
-	mergeTemplate := template.Must(template.New("merge notification").Parse(mergeString))
-	pipelineCreateTemplate := template.Must(template.New("pipeline create notification").Parse(pipelineCreateString))
-	pipelineCompleteTemplate := template.Must(template.New("pipeline complete notification").Parse(pipelineCompleteString))
-	jobCompleteTemplate := template.Must(template.New("job complete notification").Parse(jobCompleteString))
+	pushCompareTemplate := template.Must(template.New("push notification").Funcs(templateFuncs).Parse(pushCompareString))
+	pushCommitLogTemplate := template.Must(template.New("push to new branch notification").Funcs(templateFuncs).Parse(pushCommitLogString))
+	branchCreateTemplate := template.Must(template.New("branch creat notification").Funcs(templateFuncs).Parse(branchCreateString))
+	branchDeleteTemplate := template.Must(template.New("branch delete notification").Funcs(templateFuncs).Parse(branchDeleteString))
+	commitTemplate := template.Must(template.New("commit notification").Funcs(templateFuncs).Parse(commitString))
+	issueTemplate := template.Must(template.New("issue notification").Funcs(templateFuncs).Parse(issueString))
+	mergeTemplate := template.Must(template.New("merge notification").Funcs(templateFuncs).Parse(mergeString))
+	pipelineCreateTemplate := template.Must(template.New("pipeline create notification").Funcs(templateFuncs).Parse(pipelineCreateString))
+	pipelineCompleteTemplate := template.Must(template.New("pipeline complete notification").Funcs(templateFuncs).Parse(pipelineCompleteString))
+	jobCompleteTemplate := template.Must(template.New("job complete notification").Funcs(templateFuncs).Parse(jobCompleteString))
 
 	return func(wr http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
@@ -187,7 +302,8 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 		}
 
 		type User struct {
-			Name string `json:"name"`
+			Name     string `json:"name"`
+			Username string `json:"username"`
 		}
 
 		type Issue struct {
@@ -235,15 +351,17 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 		}
 
 		type MergeEvent struct {
-			User    User    `json:"user"`
-			Project Project `json:"project"`
-			Merge   Merge   `json:"object_attributes"`
+			User      User    `json:"user"`
+			Project   Project `json:"project"`
+			Merge     Merge   `json:"object_attributes"`
+			Assignees []User  `json:"assignees"`
 		}
 
 		type Pipeline struct {
 			ID       int     `json:"id"`
 			Commit   string  `json:"sha"`
 			Status   string  `json:"status"`
+			Ref      string  `json:"ref"`
 			Duration float64 `json:"duration"`
 		}
 
@@ -264,7 +382,10 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 			Status     string     `json:"build_status"`
 			Duration   float64    `json:"build_duration"`
 			Commit     string     `json:"sha"`
+			ProjectID  int        `json:"project_id"`
 			Repository Repository `json:"repository"`
+			// LogPaste links to the log of a failed job
+			LogPaste string
 		}
 
 		var buf bytes.Buffer
@@ -287,19 +408,32 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 			// shorten commit id
 			pipelineEvent.Pipeline.Commit = pipelineEvent.Pipeline.Commit[0:7]
 
+			severity := pipelineEvent.Pipeline.Status
+			url := fmt.Sprintf("%s/pipelines/%v", pipelineEvent.Project.WebURL, pipelineEvent.Pipeline.ID)
+
 			if pipelineEvent.Pipeline.Status == "running" {
 				// colorize status
 				pipelineEvent.Pipeline.Status = JobStatus[pipelineEvent.Pipeline.Status]
 
 				pipelineCreateTemplate.Execute(&buf, &pipelineEvent)
-				m.sendMessage(buf.String(), pipelineEvent.Project.Name, pipelineEvent.Project.PathWithNamespace)
+				m.sendMessage(IRCMessage{EventType: "pipeline", Messages: []string{buf.String()}, Severity: severity, URL: url}, pipelineEvent.Project.PathWithNamespace)
 
 			} else if pipelineEvent.Pipeline.Status == "success" || pipelineEvent.Pipeline.Status == "failed" {
+				// track failed pipelines per project and branch
+				name := pipelineEvent.Project.Name + " " + pipelineEvent.Pipeline.Ref
+				for _, channelName := range m.getChannels(pipelineEvent.Project.PathWithNamespace) {
+					if pipelineEvent.Pipeline.Status == "failed" {
+						Status.set(channelName, statusEntry{Kind: kindPipeline, Name: name, State: "failed"})
+					} else {
+						Status.clear(channelName, kindPipeline, name)
+					}
+				}
+
 				// colorize status
 				pipelineEvent.Pipeline.Status = JobStatus[pipelineEvent.Pipeline.Status]
 
 				pipelineCompleteTemplate.Execute(&buf, &pipelineEvent)
-				m.sendMessage(buf.String(), pipelineEvent.Project.Name, pipelineEvent.Project.PathWithNamespace)
+				m.sendMessage(IRCMessage{EventType: "pipeline", Messages: []string{buf.String()}, Severity: severity, URL: url}, pipelineEvent.Project.PathWithNamespace)
 			}
 
 		case "Job Hook":
@@ -324,11 +458,26 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 			pathWithNamespace := strings.Split(strings.Split(jobEvent.Repository.URL, ":")[1], ".")[0]
 			fmt.Println(pathWithNamespace)
 
+			severity := jobEvent.Status
+			url := fmt.Sprintf("%s/-/jobs/%v", jobEvent.Repository.Homepage, jobEvent.ID)
+
+			if jobEvent.Status == "failed" && m.apiToken != "" && Pastes.Enabled() {
+				jobLog, err := m.jobLog(jobEvent.Repository.Homepage, jobEvent.ProjectID, jobEvent.ID)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"job": url,
+					}).Errorf("Failed to fetch job log: %s", err)
+				} else {
+					// The reason of the failure is at the end of the log
+					jobEvent.LogPaste = Pastes.PasteTail(jobLog)
+				}
+			}
+
 			// colorize status
 			jobEvent.Status = JobStatus[jobEvent.Status]
 
 			jobCompleteTemplate.Execute(&buf, &jobEvent)
-			m.sendMessage(buf.String(), jobEvent.Repository.Name, pathWithNamespace)
+			m.sendMessage(IRCMessage{EventType: "job", Messages: []string{buf.String()}, Severity: severity, URL: url}, pathWithNamespace)
 
 		case "Merge Request Hook", "Merge Request Event":
 			var mergeEvent MergeEvent
@@ -343,7 +492,16 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 
 			mergeTemplate.Execute(&buf, &mergeEvent)
 
-			m.sendMessage(buf.String(), mergeEvent.Project.Name, mergeEvent.Project.PathWithNamespace)
+			message := IRCMessage{EventType: "merge_request", Messages: []string{buf.String()}, URL: mergeEvent.Merge.URL}
+			m.sendMessage(message, mergeEvent.Project.PathWithNamespace)
+			if m.notifyAssignees {
+				for _, assignee := range mergeEvent.Assignees {
+					// Nobody has to be notified about their own changes
+					if !strings.EqualFold(assignee.Username, mergeEvent.User.Username) {
+						m.sendDirect(message, assignee.Username)
+					}
+				}
+			}
 
 		case "Issue Hook", "Issue Event":
 			var issueEvent IssueEvent
@@ -358,7 +516,7 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 
 			issueTemplate.Execute(&buf, &issueEvent)
 
-			m.sendMessage(buf.String(), issueEvent.Project.Name, issueEvent.Project.PathWithNamespace)
+			m.sendMessage(IRCMessage{EventType: "issue", Messages: []string{buf.String()}, URL: issueEvent.Issue.URL}, issueEvent.Project.PathWithNamespace)
 
 		case "Push Hook", "Push Event":
 			var pushEvent PushEvent
@@ -373,27 +531,31 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 				// Branch was deleted
 				var buf bytes.Buffer
 				branchDeleteTemplate.Execute(&buf, &pushEvent)
-				m.sendMessage(buf.String(), pushEvent.Project.Name, pushEvent.Project.PathWithNamespace)
+				m.sendMessage(IRCMessage{EventType: "push", Messages: []string{buf.String()}, URL: pushEvent.Project.WebURL}, pushEvent.Project.PathWithNamespace)
 			} else {
 				if pushEvent.BeforeCommit == NullCommit {
 					// Branch was created
 					var buf bytes.Buffer
 					branchCreateTemplate.Execute(&buf, &pushEvent)
-					m.sendMessage(buf.String(), pushEvent.Project.Name, pushEvent.Project.PathWithNamespace)
+					m.sendMessage(IRCMessage{EventType: "push", Messages: []string{buf.String()}, URL: fmt.Sprintf("%s/tree/%s", pushEvent.Project.WebURL, pushEvent.Branch)}, pushEvent.Project.PathWithNamespace)
 				}
 
 				if pushEvent.TotalCommits > 0 {
 					// when the beforeCommit does not exist, we can't link to a compare without skipping the first commit
 					var buf bytes.Buffer
+					push := IRCMessage{EventType: "push"}
 					if pushEvent.BeforeCommit == NullCommit {
 						pushCommitLogTemplate.Execute(&buf, &pushEvent)
+						push.URL = fmt.Sprintf("%s/commits/%s", pushEvent.Project.WebURL, pushEvent.Branch)
 					} else {
 						pushEvent.BeforeCommit = pushEvent.BeforeCommit[0:7]
 						pushEvent.AfterCommit = pushEvent.AfterCommit[0:7]
 						pushCompareTemplate.Execute(&buf, &pushEvent)
+						push.URL = fmt.Sprintf("%s/compare/%s...%s", pushEvent.Project.WebURL, pushEvent.BeforeCommit, pushEvent.AfterCommit)
 					}
 
-					m.sendMessage(buf.String(), pushEvent.Project.Name, pushEvent.Project.PathWithNamespace)
+					// The commit list is sent together with the push notification
+					push.Messages = append(push.Messages, buf.String())
 
 					// Limit number of commit meessages to 3
 					if pushEvent.TotalCommits > m.commitLimit {
@@ -428,13 +590,15 @@ func (m GitlabModule) GetHandler() http.HandlerFunc {
 							log.Printf("ERROR: %v", err)
 							return
 						}
-						m.sendMessage(buf.String(), pushEvent.Project.Name, pushEvent.Project.PathWithNamespace)
+						push.Messages = append(push.Messages, buf.String())
 					}
 
 					if pushEvent.TotalCommits > m.commitLimit {
 						var message = fmt.Sprintf("and %d more commits.", pushEvent.TotalCommits-m.commitLimit)
-						m.sendMessage(message, pushEvent.Project.Name, pushEvent.Project.PathWithNamespace)
+						push.Messages = append(push.Messages, message)
 					}
+
+					m.sendMessage(push, pushEvent.Project.PathWithNamespace)
 				}
 			}
 
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -44,3 +45,101 @@ func TestGitlabHandler(t *testing.T) {
 			status, http.StatusOK)
 	}
 }
+
+func TestGitlabMergeAssignees(t *testing.T) {
+	config := viper.New()
+	config.Set("default_channel", "#defaultChannel")
+	config.Set("notify_assignees", true)
+	config.Set("users.alice", "alice_irc")
+
+	payload := `{
+		"user": {"name": "Bob", "username": "bob"},
+		"project": {"name": "project", "path_with_namespace": "group/project"},
+		"object_attributes": {"iid": 1, "action": "open", "title": "Fix it", "url": "https://gitlab.example.com/group/project/-/merge_requests/1"},
+		"assignees": [{"name": "Alice", "username": "Alice"}, {"name": "Bob", "username": "bob"}, {"name": "Carol", "username": "carol"}]
+	}`
+	req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+
+	c := make(chan IRCMessage, 10)
+	gitlabModule := &GitlabModule{}
+	gitlabModule.Init(config, &c)
+	gitlabModule.GetHandler().ServeHTTP(httptest.NewRecorder(), req)
+	close(c)
+
+	var targets []string
+	for message := range c {
+		targets = append(targets, message.Channel)
+	}
+	// The author of the merge request isn't notified about it
+	want := []string{"#defaultChannel", "@alice_irc", "@carol"}
+	if strings.Join(targets, " ") != strings.Join(want, " ") {
+		t.Errorf("Merge request was sent to %v, wanted %v", targets, want)
+	}
+}
+
+func TestGitlabFailedJobLog(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/42/jobs/1337/trace" || r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("section_start:1700000000:step_script\r\x1b[0K\x1b[32;1m$ make test\x1b[0;m\nFAIL: TestSomething\n"))
+	}))
+	defer api.Close()
+
+	previous := Pastes
+	Pastes = &PasteService{}
+	t.Cleanup(func() { Pastes = previous })
+	if err := Pastes.Configure("https://cpthook.example.com", viper.New()); err != nil {
+		t.Fatal(err)
+	}
+
+	config := viper.New()
+	config.Set("default_channel", "#defaultChannel")
+	config.Set("api_token", "secret")
+	payload := `{
+		"build_id": 1337,
+		"build_name": "test",
+		"build_status": "failed",
+		"sha": "0123456789abcdef",
+		"project_id": 42,
+		"repository": {"name": "project", "homepage": "` + api.URL + `/group/project", "url": "git@gitlab.example.com:group/project.git"}
+	}`
+	req, err := http.NewRequest("POST", "/", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Gitlab-Event", "Job Hook")
+
+	c := make(chan IRCMessage, 10)
+	gitlabModule := &GitlabModule{}
+	gitlabModule.Init(config, &c)
+	gitlabModule.GetHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+	message := <-c
+	_, link, found := strings.Cut(message.Messages[0], "https://cpthook.example.com/p/")
+	if !found {
+		t.Fatalf("Message of the failed job doesn't link to its log: %q", message.Messages[0])
+	}
+	if text, _ := Pastes.store.get(link); text != "$ make test\nFAIL: TestSomething" {
+		t.Errorf("Pasted job log is %q", text)
+	}
+}
+
+func TestReadTail(t *testing.T) {
+	log := strings.Repeat("0123456789", 10000)
+	for _, n := range []int{1, 10, 40000, 200000} {
+		tail, err := readTail(strings.NewReader(log), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := log[max(len(log)-n, 0):]; string(tail) != want {
+			t.Errorf("readTail with %d bytes returned %d bytes, wanted %d", n, len(tail), len(want))
+		}
+	}
+}
@@ -1,14 +1,53 @@
 package input
 
 import (
+	"fmt"
 	"math/rand"
 	"net/http"
+	"text/template"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// UserTargetPrefix marks targets which are users instead of channels, e.g. "@alice"
+const UserTargetPrefix = "@"
+
 var letterRunes = []rune("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
+// templateFuncs are available in all module templates
+var templateFuncs = template.FuncMap{
+	"markdown": func(value interface{}) string {
+		// Missing values, e.g. an annotation which isn't set, are left empty
+		if value == nil {
+			return ""
+		}
+		return markdownToIRC(fmt.Sprint(value))
+	},
+	"shorten": func(url string) string {
+		return Links.Shorten(url)
+	},
+	// shortLink is like shorten, but returns nothing when the shortener is disabled, for
+	// links which are too long to be shown otherwise
+	"shortLink": func(url string) string {
+		if !Links.Enabled() || url == "" {
+			return ""
+		}
+		return Links.Shorten(url)
+	},
+	// paste returns a link to the full text, when it doesn't fit into an IRC message
+	"paste": func(value interface{}) string {
+		if value == nil {
+			return ""
+		}
+		text := fmt.Sprint(value)
+		if !Pastes.Needed(text) {
+			return ""
+		}
+		return Pastes.Paste(text)
+	},
+}
+
 // Module defines a common interface for all CptHook modules
 type Module interface {
 	Init(c *viper.Viper, channel *chan IRCMessage)
@@ -21,6 +60,18 @@ type IRCMessage struct {
 	ID       string
 	Messages []string
 	Channel  string
+	// Module is the type of module which created the message, e.g. "Gitlab"
+	Module string
+	// Severity optionally classifies the event, e.g. "critical" or "resolved"
+	Severity string
+	// URL is the most relevant link for the event, if there is one
+	URL string
+	// EventType is the kind of event within the module, e.g. "push" or "firing"
+	EventType string
+	// Block is the name of the configuration block of the module. It is set by CptHook itself.
+	Block string
+	// Received is the time CptHook received the message. It is set by CptHook itself.
+	Received time.Time
 }
 
 func (m *IRCMessage) generateID() {
@@ -3,6 +3,7 @@ package input
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 	"text/template"
@@ -154,7 +155,7 @@ func (m *Icinga2Module) Init(c *viper.Viper, channel *chan IRCMessage) {
 	m.channel = *channel
 }
 
-func (m Icinga2Module) sendMessage(message string, notification Notification) {
+func (m Icinga2Module) getChannels(notification Notification) []string {
 	var channelNames []string
 	var hostname = notification.Host.Name
 	if list := contains(m.channelMapping.ExplicitMappings, hostname); len(list) > 0 { // Check if explicit mapping exists
@@ -175,10 +176,47 @@ func (m Icinga2Module) sendMessage(message string, notification Notification) {
 			channelNames = append(channelNames, m.channelMapping.DefaultChannel)
 		}
 	}
+	return channelNames
+}
+
+// trackStatus updates the status tracker with the current state of the host or service
+func (m Icinga2Module) trackStatus(notification Notification) {
+	if notification.Type == "ACKNOWLEDGEMENT" {
+		return
+	}
+	for _, channelName := range m.getChannels(notification) {
+		switch notification.Target {
+		case "service":
+			name := notification.Service.DisplayName + " on " + notification.Host.DisplayName
+			if notification.Service.State == "OK" {
+				Status.clear(channelName, kindService, name)
+			} else {
+				Status.set(channelName, statusEntry{Kind: kindService, Name: name, State: notification.Service.State})
+			}
+		case "host":
+			if notification.Host.State == "UP" {
+				Status.clear(channelName, kindHost, notification.Host.DisplayName)
+			} else {
+				Status.set(channelName, statusEntry{Kind: kindHost, Name: notification.Host.DisplayName, State: notification.Host.State})
+			}
+		}
+	}
+}
 
-	for _, channelName := range channelNames {
-		var event IRCMessage
-		event.Messages = append(event.Messages, message)
+func (m Icinga2Module) sendMessage(notification Notification, messages ...string) {
+	var event IRCMessage
+	event.Messages = messages
+	event.Module = "Icinga2"
+	event.EventType = strings.ToLower(notification.Type)
+	if notification.Target == "service" {
+		event.Severity = strings.ToLower(notification.Service.State)
+		event.URL = notification.Service.WebURL
+	} else {
+		event.Severity = strings.ToLower(notification.Host.State)
+		event.URL = notification.Host.WebURL
+	}
+
+	for _, channelName := range m.getChannels(notification) {
 		event.Channel = channelName
 		event.generateID()
 		log.WithFields(log.Fields{
@@ -207,6 +245,26 @@ func (m Icinga2Module) GetChannelList() []string {
 	return all
 }
 
+// Commands returns the IRC commands of the module
+func (m Icinga2Module) Commands() []Command {
+	return []Command{
+		statusCommand("problems", "Shows the hosts which are down and the services with problems of a channel", m.channelMapping.DefaultChannel, func(s StatusSummary) string {
+			if s.HostsDown == 0 && len(s.Services) == 0 {
+				return "No problems"
+			}
+			var parts []string
+			if s.HostsDown > 0 {
+				parts = append(parts, fmt.Sprintf("%d hosts down: %s", s.HostsDown, listNames(s.Hosts)))
+			}
+			if len(s.Services) > 0 {
+				parts = append(parts, fmt.Sprintf("%d services critical, %d warning, %d unknown: %s",
+					s.ServicesCritical, s.ServicesWarning, s.ServicesUnknown, listNames(s.Services)))
+			}
+			return strings.Join(parts, " | ")
+		}),
+	}
+}
+
 func (m Icinga2Module) GetHandler() http.HandlerFunc {
 
 	const serviceStateChangeString = "Service \x0312{{ .Service.DisplayName }}\x03 (\x0314{{ .Host.DisplayName }}\x03) transitioned from state {{ .Service.ColoredLastState }} to {{ .Service.ColoredState }}"
@@ -214,27 +272,27 @@ func (m Icinga2Module) GetHandler() http.HandlerFunc {
 	const serviceStateString = "Service \x0312{{ .Service.DisplayName }}\x03 (\x0314{{ .Host.DisplayName }}\x03) is still in state {{ .Service.ColoredState }} ({{ .Service.AgoString }})"
 	const serviceAckString = "{{ .Author }} acknowledged service \x0312{{ .Service.DisplayName }}\x03 (State {{ .Service.ColoredState }} {{ .Service.AgoString }})"
 	const serviceRecoveryString = "Service \x0312{{ .Service.DisplayName }}\x03 (\x0314{{ .Host.DisplayName }}\x03) \x0303recovered\x03 from state {{ .Service.ColoredLastState }}"
-	const serviceOutputString = "→ {{ .Service.Output }}"
+	const serviceOutputString = "→ {{ markdown .Service.Output }}{{ with paste .Service.Output }} {{ . }}{{ end }}"
 
 	const hostStateChangeString = "Host \x0312{{ .Host.DisplayName }}\x03 transitioned from state {{ .Host.ColoredLastState }} to {{ .Host.ColoredState }}"
 	const hostStateEnteredString = "Host \x0312{{ .Host.DisplayName }}\x03 entered state {{ .Host.ColoredState }}"
 	const hostStateString = "Host \x0312{{ .Host.DisplayName }}\x03 is still in state {{ .Host.ColoredState }} ({{ .Host.AgoString }})"
 	const hostAckString = "{{ .Author }} acknowledged host \x0312{{ .Host.DisplayName }}\x03 (State {{ .Host.ColoredState }} {{ .Host.AgoString }})"
 	const hostRecoveryString = "Host \x0312{{ .Host.DisplayName }}\x03 \x0303recovered\x03 from state {{ .Host.ColoredLastState }}"
-	const hostOutputString = "→ {{ .Host.Output }}"
-
-	serviceStateChangeTemplate := template.Must(template.New("hostOutput").Parse(serviceStateChangeString))
-	serviceStateEnteredTemplate := template.Must(template.New("hostOutput").Parse(serviceStateEnteredString))
-	serviceStateTemplate := template.Must(template.New("serviceState").Parse(serviceStateString))
-	serviceAckTemplate := template.Must(template.New("serviceState").Parse(serviceAckString))
-	serviceRecoveryTemplate := template.Must(template.New("serviceState").Parse(serviceRecoveryString))
-	serviceOutputTemplate := template.Must(template.New("serviceOutput").Parse(serviceOutputString))
-	hostStateChangeTemplate := template.Must(template.New("hostOutput").Parse(hostStateChangeString))
-	hostStateEnteredTemplate := template.Must(template.New("hostOutput").Parse(hostStateEnteredString))
-	hostStateTemplate := template.Must(template.New("hostState").Parse(hostStateString))
-	hostAckTemplate := template.Must(template.New("serviceState").Parse(hostAckString))
-	hostRecoveryTemplate := template.Must(template.New("serviceState").Parse(hostRecoveryString))
-	hostOutputTemplate := template.Must(template.New("hostOutput").Parse(hostOutputString))
+	const hostOutputString = "→ {{ markdown .Host.Output }}{{ with paste .Host.Output }} {{ . }}{{ end }}"
+
+	serviceStateChangeTemplate := template.Must(template.New("hostOutput").Funcs(templateFuncs).Parse(serviceStateChangeString))
+	serviceStateEnteredTemplate := template.Must(template.New("hostOutput").Funcs(templateFuncs).Parse(serviceStateEnteredString))
+	serviceStateTemplate := template.Must(template.New("serviceState").Funcs(templateFuncs).Parse(serviceStateString))
+	serviceAckTemplate := template.Must(template.New("serviceState").Funcs(templateFuncs).Parse(serviceAckString))
+	serviceRecoveryTemplate := template.Must(template.New("serviceState").Funcs(templateFuncs).Parse(serviceRecoveryString))
+	serviceOutputTemplate := template.Must(template.New("serviceOutput").Funcs(templateFuncs).Parse(serviceOutputString))
+	hostStateChangeTemplate := template.Must(template.New("hostOutput").Funcs(templateFuncs).Parse(hostStateChangeString))
+	hostStateEnteredTemplate := template.Must(template.New("hostOutput").Funcs(templateFuncs).Parse(hostStateEnteredString))
+	hostStateTemplate := template.Must(template.New("hostState").Funcs(templateFuncs).Parse(hostStateString))
+	hostAckTemplate := template.Must(template.New("serviceState").Funcs(templateFuncs).Parse(hostAckString))
+	hostRecoveryTemplate := template.Must(template.New("serviceState").Funcs(templateFuncs).Parse(hostRecoveryString))
+	hostOutputTemplate := template.Must(template.New("hostOutput").Funcs(templateFuncs).Parse(hostOutputString))
 
 	return func(wr http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
@@ -251,54 +309,56 @@ func (m Icinga2Module) GetHandler() http.HandlerFunc {
 			"event": notification.Target,
 		}).Warn("Got a request for the Icinga2Module")
 
+		m.trackStatus(notification)
+
 		switch notification.Target {
 
 		case "service":
 			if notification.Type == "ACKNOWLEDGEMENT" { // Acknowledge
 				serviceAckTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, buf.String())
 			} else if notification.Type == "RECOVERY" { // Recovery
 				serviceRecoveryTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, buf.String())
 			} else if notification.Service.LastStateType != notification.Service.StateType { // State entered
 				serviceStateEnteredTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				message := buf.String()
 				buf.Reset()
 				serviceOutputTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, message, buf.String())
 			} else if notification.Service.LastState == notification.Service.State { // Renotification
 				serviceStateTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, buf.String())
 			} else { // State changed
 				serviceStateChangeTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				message := buf.String()
 				buf.Reset()
 				serviceOutputTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, message, buf.String())
 			}
 
 		case "host":
 			if notification.Type == "ACKNOWLEDGEMENT" { // Acknowledge
 				hostAckTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, buf.String())
 			} else if notification.Type == "RECOVERY" { // Recovery
 				hostRecoveryTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, buf.String())
 			} else if notification.Host.LastStateType != notification.Host.StateType { // State entered
 				hostStateEnteredTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				message := buf.String()
 				buf.Reset()
 				hostOutputTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, message, buf.String())
 			} else if notification.Host.LastState == notification.Host.State { // Renotification
 				hostStateTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, buf.String())
 			} else { // State changed
 				hostStateChangeTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				message := buf.String()
 				buf.Reset()
 				hostOutputTemplate.Execute(&buf, &notification)
-				m.sendMessage(buf.String(), notification)
+				m.sendMessage(notification, message, buf.String())
 			}
 		default:
 			log.WithFields(log.Fields{
@@ -0,0 +1,96 @@
+package input
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// IRC formatting codes
+const (
+	ircBold          = "\x02"
+	ircItalic        = "\x1D"
+	ircStrikethrough = "\x1E"
+	ircMonospace     = "\x11"
+)
+
+var (
+	// controlChars are removed from the input, so it can't contain its own formatting codes or line breaks
+	controlChars = regexp.MustCompile(`[\x00-\x1F\x7F]+`)
+
+	htmlComment   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlBreak     = regexp.MustCompile(`(?i)<br\s*/?>|</?p\b[^>]*>`)
+	htmlBold      = regexp.MustCompile(`(?is)<(?:b|strong)\b[^>]*>(.*?)</(?:b|strong)>`)
+	htmlItalic    = regexp.MustCompile(`(?is)<(?:i|em)\b[^>]*>(.*?)</(?:i|em)>`)
+	htmlStrike    = regexp.MustCompile(`(?is)<(?:s|del|strike)\b[^>]*>(.*?)</(?:s|del|strike)>`)
+	htmlCode      = regexp.MustCompile("(?is)<(?:code|tt|kbd|pre)\\b[^>]*>([^`]*?)</(?:code|tt|kbd|pre)>")
+	htmlLink      = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	htmlTag       = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	autoLink      = regexp.MustCompile(`<((?:https?|ftp|mailto):[^>\s]+)>`)
+	markdownToken = regexp.MustCompile("`([^`]+)`|!?\\[([^\\]]*)\\]\\(([^)\\s]+)(?:\\s+\"[^\"]*\")?\\)")
+
+	markdownHeading    = regexp.MustCompile(`^\s*#{1,6}\s+`)
+	markdownQuote      = regexp.MustCompile(`^\s*>\s?`)
+	markdownBold       = regexp.MustCompile(`\*\*([^*\s](?:[^*]*[^*\s])?)\*\*|__([^_\s](?:[^_]*[^_\s])?)__`)
+	markdownItalicStar = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*`)
+	markdownItalicLine = regexp.MustCompile(`(^|[^\w_])_([^_\s](?:[^_]*[^_\s])?)_($|[^\w_])`)
+	markdownStrike     = regexp.MustCompile(`~~([^~\s](?:[^~]*[^~\s])?)~~`)
+)
+
+// markdownToIRC converts Markdown and HTML emphasis, inline code and links into IRC
+// formatting codes. Links are written as "text <url>". Unsupported markup is removed
+// and the result is always a single line.
+func markdownToIRC(text string) string {
+	text = controlChars.ReplaceAllString(text, " ")
+	text = htmlToMarkdown(text)
+	text = controlChars.ReplaceAllString(text, " ")
+	text = markdownHeading.ReplaceAllString(text, "")
+	text = markdownQuote.ReplaceAllString(text, "")
+
+	// Inline code and links are converted as a whole, so their content isn't formatted
+	var out strings.Builder
+	last := 0
+	for _, m := range markdownToken.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(markdownEmphasis(text[last:m[0]]))
+		if m[2] >= 0 {
+			out.WriteString(ircMonospace + text[m[2]:m[3]] + ircMonospace)
+		} else {
+			out.WriteString(formatLink(markdownEmphasis(text[m[4]:m[5]]), text[m[6]:m[7]]))
+		}
+		last = m[1]
+	}
+	out.WriteString(markdownEmphasis(text[last:]))
+
+	return strings.TrimSpace(out.String())
+}
+
+// htmlToMarkdown rewrites the supported HTML tags as Markdown, removes all other tags
+// and decodes entities
+func htmlToMarkdown(text string) string {
+	text = htmlComment.ReplaceAllString(text, "")
+	text = autoLink.ReplaceAllString(text, "$1")
+	text = htmlBreak.ReplaceAllString(text, " ")
+	text = htmlCode.ReplaceAllString(text, "`$1`")
+	text = htmlLink.ReplaceAllString(text, "[$2]($1)")
+	text = htmlBold.ReplaceAllString(text, "**$1**")
+	text = htmlItalic.ReplaceAllString(text, "*$1*")
+	text = htmlStrike.ReplaceAllString(text, "~~$1~~")
+	text = htmlTag.ReplaceAllString(text, "")
+	return html.UnescapeString(text)
+}
+
+func markdownEmphasis(text string) string {
+	text = markdownBold.ReplaceAllString(text, ircBold+"$1$2"+ircBold)
+	text = markdownItalicStar.ReplaceAllString(text, ircItalic+"$1"+ircItalic)
+	text = markdownItalicLine.ReplaceAllString(text, "$1"+ircItalic+"$2"+ircItalic+"$3")
+	text = markdownStrike.ReplaceAllString(text, ircStrikethrough+"$1"+ircStrikethrough)
+	return text
+}
+
+func formatLink(text, url string) string {
+	text = strings.TrimSpace(text)
+	if text == "" || text == url {
+		return url
+	}
+	return text + " <" + url + ">"
+}
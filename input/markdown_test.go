@@ -0,0 +1,28 @@
+package input
+
+import "testing"
+
+func TestMarkdownToIRC(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Fix **login** with *SSO*", "Fix \x02login\x02 with \x1DSSO\x1D"},
+		{"Rename `snake_case_name` in __init__", "Rename \x11snake_case_name\x11 in \x02init\x02"},
+		{"keep snake_case_name, but _emphasize_ this", "keep snake_case_name, but \x1Demphasize\x1D this"},
+		{"See [the docs](https://example.com/a_b_c) or https://example.com/x_y_z", "See the docs <https://example.com/a_b_c> or https://example.com/x_y_z"},
+		{"[https://example.com](https://example.com)", "https://example.com"},
+		{"## Disk is ~~full~~ almost full", "Disk is \x1Efull\x1E almost full"},
+		{"<b>Load</b> is <i>high</i>: <code>5.3</code>", "\x02Load\x02 is \x1Dhigh\x1D: \x115.3\x11"},
+		{"Check <a href=\"https://example.com/graph\">the graph</a><br/>now", "Check the graph <https://example.com/graph> now"},
+		{"<script>alert(1)</script> 1 &lt; 2 &amp;&amp; <https://example.com>", "alert(1) 1 < 2 && https://example.com"},
+		{"CRITICAL\x0304 injected\x03\nsecond line", "CRITICAL 04 injected second line"},
+		{"2 * 3 * 4 is not emphasis", "2 * 3 * 4 is not emphasis"},
+	}
+
+	for _, test := range tests {
+		if got := markdownToIRC(test.input); got != test.want {
+			t.Errorf("markdownToIRC(%q) returned %q, wanted %q", test.input, got, test.want)
+		}
+	}
+}
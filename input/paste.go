@@ -0,0 +1,143 @@
+package input
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/viper"
+)
+
+const pasteTruncated = "\n[truncated]"
+const pasteTruncatedStart = "[truncated]\n"
+
+// pasteStore is implemented by dirStore, which keeps every paste in its own file, and
+// by fileStore without a path, which keeps them in memory
+type pasteStore interface {
+	put(value string, reuse bool) (string, error)
+	get(key string) (string, bool)
+	size() int
+	Close() error
+}
+
+// PasteService stores long texts, which don't fit into an IRC message, and serves them
+// from CptHook's own HTTP server
+type PasteService struct {
+	publicURL string
+	endpoint  string
+	minLength int
+	maxSize   int
+	maxTotal  int
+	store     pasteStore
+}
+
+// Pastes is the paste service used by the modules. Nothing is pasted until it was configured.
+var Pastes = &PasteService{}
+
+// Configure enables the paste service. Links are built from publicURL, the URL under
+// which the HTTP server of CptHook is reachable, and the configured endpoint.
+func (p *PasteService) Configure(publicURL string, config *viper.Viper) error {
+	if publicURL == "" {
+		return fmt.Errorf("http.public_url is required to serve pastes")
+	}
+
+	config.SetDefault("endpoint", "/p/")
+	config.SetDefault("retention", 7*24*time.Hour)
+	config.SetDefault("min_length", 300)
+	config.SetDefault("max_size", 64*1024)
+	config.SetDefault("max_total_size", 10*1024*1024)
+
+	// Pastes get longer keys than short links, as they may contain internal information
+	var store pasteStore
+	var err error
+	if dir := config.GetString("directory"); dir != "" {
+		store, err = newDirStore(dir, config.GetDuration("retention"), 10)
+	} else {
+		store, err = newFileStore("", config.GetDuration("retention"), 10)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the paste store: %s", err)
+	}
+
+	p.publicURL = strings.TrimSuffix(publicURL, "/")
+	p.endpoint = "/" + strings.Trim(config.GetString("endpoint"), "/") + "/"
+	p.minLength = config.GetInt("min_length")
+	p.maxSize = config.GetInt("max_size")
+	p.maxTotal = config.GetInt("max_total_size")
+	if p.store != nil {
+		p.store.Close()
+	}
+	p.store = store
+	return nil
+}
+
+// Enabled reports if the paste service was configured
+func (p *PasteService) Enabled() bool {
+	return p.store != nil
+}
+
+// Endpoint is the path under which the pastes are served
+func (p *PasteService) Endpoint() string {
+	return p.endpoint
+}
+
+// Needed reports if the text has to be pasted to be shown completely, because it has
+// more than one line or is longer than min_length
+func (p *PasteService) Needed(text string) bool {
+	text = strings.TrimSpace(text)
+	return p.Enabled() && (strings.Contains(text, "\n") || len(text) > p.minLength)
+}
+
+// Paste stores the text and returns the link to it. Texts longer than max_size are
+// truncated. An empty string is returned when the paste service is disabled or the
+// text can't be stored.
+func (p *PasteService) Paste(text string) string {
+	if !p.Enabled() {
+		return ""
+	}
+	text = strings.TrimSpace(text)
+	if len(text) > p.maxSize {
+		text = strings.ToValidUTF8(text[:max(p.maxSize-len(pasteTruncated), 0)], "") + pasteTruncated
+	}
+	if p.store.size()+len(text) > p.maxTotal {
+		log.Warn("Paste store is full. Not pasting text")
+		return ""
+	}
+
+	key, err := p.store.put(text, true)
+	if err != nil {
+		log.Errorf("Failed to store paste: %s", err)
+		return ""
+	}
+	return p.publicURL + p.endpoint + key
+}
+
+// PasteTail stores the text like Paste, but keeps the end of texts longer than max_size
+func (p *PasteService) PasteTail(text string) string {
+	if !p.Enabled() {
+		return ""
+	}
+	text = strings.TrimSpace(text)
+	if len(text) > p.maxSize {
+		text = pasteTruncatedStart + strings.ToValidUTF8(text[len(text)-max(p.maxSize-len(pasteTruncatedStart), 0):], "")
+	}
+	return p.Paste(text)
+}
+
+// Handler serves the pastes as plain text
+func (p *PasteService) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.Trim(strings.TrimPrefix(r.URL.Path, p.endpoint), "/")
+		text, ok := p.store.get(key)
+		if !ok {
+			http.Error(w, "Unknown or expired paste", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write([]byte(text + "\n"))
+	}
+}
@@ -0,0 +1,49 @@
+package input
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPasteService(t *testing.T) {
+	config := viper.New()
+	config.Set("min_length", 20)
+	config.Set("max_size", 100)
+
+	p := &PasteService{}
+	if err := p.Configure("https://cpthook.example.com", config); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Needed("short output") {
+		t.Errorf("Paste service wants to paste a short single line")
+	}
+	if !p.Needed("CRITICAL - 2 errors\nerror one\nerror two") {
+		t.Errorf("Paste service doesn't want to paste multiple lines")
+	}
+
+	link := p.Paste(strings.Repeat("line of plugin output\n", 10))
+	if !strings.HasPrefix(link, "https://cpthook.example.com/p/") {
+		t.Fatalf("Paste service returned unexpected link: %q", link)
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimPrefix(link, "https://cpthook.example.com"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Handler returned wrong status code: got %v wanted %v", status, http.StatusOK)
+	}
+	body, _ := io.ReadAll(rr.Body)
+	if len(body) > 101 || !strings.HasSuffix(string(body), pasteTruncated+"\n") {
+		t.Errorf("Handler returned a paste which wasn't truncated: %q", body)
+	}
+}
@@ -3,9 +3,11 @@ package input
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -22,10 +24,11 @@ type PrometheusModule struct {
 }
 
 type alert struct {
-	Labels      map[string]interface{} `json:"labels"`
-	Annotations map[string]interface{} `json:"annotations"`
-	StartsAt    string                 `json:"startsAt"`
-	EndsAt      string                 `json:"endsAt"`
+	Labels       map[string]interface{} `json:"labels"`
+	Annotations  map[string]interface{} `json:"annotations"`
+	StartsAt     string                 `json:"startsAt"`
+	EndsAt       string                 `json:"endsAt"`
+	GeneratorURL string                 `json:"generatorURL"`
 }
 
 type notification struct {
@@ -47,6 +50,8 @@ type notificationContext struct {
 	Status        string
 	ColorStart    string
 	ColorEnd      string
+	// AnnotationsPaste links to all annotations, when the description is too long for IRC
+	AnnotationsPaste string
 }
 
 type instance struct {
@@ -94,6 +99,18 @@ func (m PrometheusModule) GetChannelList() []string {
 	return []string{m.defaultChannel}
 }
 
+// Commands returns the IRC commands of the module
+func (m PrometheusModule) Commands() []Command {
+	return []Command{
+		statusCommand("alerts", "Shows the firing alerts of a channel", m.defaultChannel, func(s StatusSummary) string {
+			if s.AlertsFiring == 0 {
+				return "No alerts firing"
+			}
+			return fmt.Sprintf("%d alerts firing: %s", s.AlertsFiring, listNames(s.Alerts))
+		}),
+	}
+}
+
 func (m *PrometheusModule) Init(c *viper.Viper, channel *chan IRCMessage) {
 	m.defaultChannel = c.GetString("channel")
 	pattern, err := regexp.Compile(c.GetString("hostname_filter"))
@@ -106,13 +123,13 @@ func (m *PrometheusModule) Init(c *viper.Viper, channel *chan IRCMessage) {
 
 func (m PrometheusModule) GetHandler() http.HandlerFunc {
 
-	const firingTemplateString = "[{{ .ColorStart }}{{ .Status }}{{ .ColorEnd }}:{{ .InstanceCount }}] {{ .Alert.Labels.alertname}} - {{ .Alert.Annotations.description}}"
+	const firingTemplateString = "[{{ .ColorStart }}{{ .Status }}{{ .ColorEnd }}:{{ .InstanceCount }}] {{ .Alert.Labels.alertname}} - {{ markdown .Alert.Annotations.description }}{{ with .AnnotationsPaste }} {{ . }}{{ end }}{{ with shortLink .Alert.GeneratorURL }} {{ . }}{{ end }}"
 	const resolvedTemplateString = "[{{ .ColorStart }}{{ .Status }}{{ .ColorEnd }}:{{ .InstanceCount }}] {{ .Alert.Labels.alertname}}"
 	const hostListTemplateString = "→ {{range $i, $instance := . }}{{if $i}}, {{end}}{{$instance.Name}}{{if $instance.Value}} ({{$instance.Value}}){{end}}{{end}}"
 
-	firingTemplate := template.Must(template.New("notification").Parse(firingTemplateString))
-	resolvedTemplate := template.Must(template.New("notification").Parse(resolvedTemplateString))
-	hostListTemplate := template.Must(template.New("notification").Parse(hostListTemplateString))
+	firingTemplate := template.Must(template.New("notification").Funcs(templateFuncs).Parse(firingTemplateString))
+	resolvedTemplate := template.Must(template.New("notification").Funcs(templateFuncs).Parse(resolvedTemplateString))
+	hostListTemplate := template.Must(template.New("notification").Funcs(templateFuncs).Parse(hostListTemplateString))
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Debug("Got a request for the PrometheusModule")
@@ -148,6 +165,7 @@ func (m PrometheusModule) GetHandler() http.HandlerFunc {
 
 			for _, alert := range alertList {
 				name := getNameFromLabels(&alert, m.hostnameFilter)
+				m.trackStatus(alertStatus, &alert, name)
 				value, ok := alert.Annotations["value"].(string)
 				if ok {
 					inst = instance{Name: name, Value: value}
@@ -165,6 +183,9 @@ func (m PrometheusModule) GetHandler() http.HandlerFunc {
 				ColorStart:    getColorcode(alertStatus),
 				ColorEnd:      "\x03",
 			}
+			if description, ok := context.Alert.Annotations["description"].(string); ok && alertStatus == "firing" && Pastes.Needed(description) {
+				context.AnnotationsPaste = Pastes.Paste(formatAnnotations(context.Alert.Annotations))
+			}
 
 			if context.InstanceCount > 0 {
 				// Sort instances
@@ -180,6 +201,13 @@ func (m PrometheusModule) GetHandler() http.HandlerFunc {
 				_ = hostListTemplate.Execute(&buf, &instanceList)
 				event.Messages = append(event.Messages, buf.String())
 				event.Channel = m.defaultChannel
+				event.Module = "Prometheus"
+				event.EventType = alertStatus
+				event.URL = alertList[0].GeneratorURL
+				event.Severity = alertStatus
+				if severity, ok := alertList[0].Labels["severity"].(string); ok && alertStatus == "firing" {
+					event.Severity = severity
+				}
 				event.generateID()
 				log.WithFields(log.Fields{
 					"MsgID":  event.ID,
@@ -192,6 +220,34 @@ func (m PrometheusModule) GetHandler() http.HandlerFunc {
 
 }
 
+// trackStatus updates the status tracker with the state of a single alert
+func (m PrometheusModule) trackStatus(status string, alert *alert, instanceName string) {
+	name, _ := alert.Labels["alertname"].(string)
+	if instanceName != name {
+		name = name + " on " + instanceName
+	}
+	if status == "firing" {
+		Status.set(m.defaultChannel, statusEntry{Kind: kindAlert, Name: name, State: status})
+	} else {
+		Status.clear(m.defaultChannel, kindAlert, name)
+	}
+}
+
+// formatAnnotations lists all annotations of an alert sorted by name, e.g. for a paste
+func formatAnnotations(annotations map[string]interface{}) string {
+	var names []string
+	for name := range annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s: %v", name, annotations[name]))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // getNameFromLabels tries to determine a meaningful name for an alert
 // If the alert has no 'instance' label, we use the 'alertname' which should always
 // be present in an alert
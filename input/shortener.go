@@ -0,0 +1,89 @@
+package input
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/viper"
+)
+
+// Shortener serves short links for the long URLs in messages from CptHook's own HTTP server
+type Shortener struct {
+	publicURL string
+	endpoint  string
+	minLength int
+	store     *fileStore
+}
+
+// Links is the shortener used by the shorten function in the module templates. It
+// only shortens URLs after it was configured.
+var Links = &Shortener{}
+
+// Configure enables the shortener. Short links are built from publicURL, the URL under
+// which the HTTP server of CptHook is reachable, and the configured endpoint.
+func (s *Shortener) Configure(publicURL string, config *viper.Viper) error {
+	if publicURL == "" {
+		return fmt.Errorf("http.public_url is required to serve short links")
+	}
+
+	config.SetDefault("endpoint", "/l/")
+	config.SetDefault("expiry", 30*24*time.Hour)
+	config.SetDefault("min_length", 40)
+
+	store, err := newFileStore(config.GetString("store"), config.GetDuration("expiry"), 5)
+	if err != nil {
+		return fmt.Errorf("failed to load the link store: %s", err)
+	}
+
+	s.publicURL = strings.TrimSuffix(publicURL, "/")
+	s.endpoint = "/" + strings.Trim(config.GetString("endpoint"), "/") + "/"
+	s.minLength = config.GetInt("min_length")
+	if s.store != nil {
+		s.store.Close()
+	}
+	s.store = store
+	return nil
+}
+
+// Enabled reports if the shortener was configured
+func (s *Shortener) Enabled() bool {
+	return s.store != nil
+}
+
+// Endpoint is the path under which the short links are served
+func (s *Shortener) Endpoint() string {
+	return s.endpoint
+}
+
+// Shorten returns a short link for the URL. The URL is returned unchanged when the
+// shortener is disabled, the URL is already short or the link can't be stored.
+func (s *Shortener) Shorten(url string) string {
+	if !s.Enabled() || len(url) < s.minLength {
+		return url
+	}
+	key, err := s.store.put(url, true)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"url": url,
+		}).Errorf("Failed to store short link: %s", err)
+		return url
+	}
+	return s.publicURL + s.endpoint + key
+}
+
+// Handler redirects short links to their URL
+func (s *Shortener) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.Trim(strings.TrimPrefix(r.URL.Path, s.endpoint), "/")
+		url, ok := s.store.get(key)
+		if !ok {
+			http.Error(w, "Unknown or expired link", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
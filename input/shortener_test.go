@@ -0,0 +1,53 @@
+package input
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestShortener(t *testing.T) {
+	config := viper.New()
+	config.Set("store", filepath.Join(t.TempDir(), "links.json"))
+
+	s := &Shortener{}
+	if err := s.Configure("https://cpthook.example.com/", config); err != nil {
+		t.Fatal(err)
+	}
+
+	long := "https://gitlab.example.com/group/project/compare/0123456789abcdef...fedcba9876543210"
+	short := s.Shorten(long)
+	if !strings.HasPrefix(short, "https://cpthook.example.com/l/") {
+		t.Fatalf("Shortener returned unexpected link: %q", short)
+	}
+	if again := s.Shorten(long); again != short {
+		t.Errorf("Shortener returned a new link for the same URL: got %q wanted %q", again, short)
+	}
+	if url := "https://example.com"; s.Shorten(url) != url {
+		t.Errorf("Shortener shortened a short URL")
+	}
+
+	// A new shortener with the same store knows the link after a restart
+	restarted := &Shortener{}
+	if err := restarted.Configure("https://cpthook.example.com", config); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimPrefix(short, "https://cpthook.example.com"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	restarted.Handler().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("Handler returned wrong status code: got %v wanted %v", status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != long {
+		t.Errorf("Handler redirected to the wrong URL: got %q wanted %q", location, long)
+	}
+}
@@ -46,8 +46,12 @@ func (m SimpleModule) GetHandler() http.HandlerFunc {
 
 		// Send message
 		msg := IRCMessage{
-			Messages: lines,
-			Channel:  channel,
+			Messages:  lines,
+			Channel:   channel,
+			Module:    "Simple",
+			EventType: query.Get("event"),
+			Severity:  query.Get("severity"),
+			URL:       query.Get("url"),
 		}
 		msg.generateID()
 		log.WithFields(log.Fields{
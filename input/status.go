@@ -0,0 +1,99 @@
+package input
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// statusEntry is a single tracked problem, e.g. a firing alert or a host which is down
+type statusEntry struct {
+	Kind  string
+	Name  string
+	State string
+}
+
+const (
+	kindAlert    = "alert"
+	kindHost     = "host"
+	kindService  = "service"
+	kindPipeline = "pipeline"
+)
+
+// StatusTracker keeps track of the current problems per channel, so other parts of
+// CptHook can show a summary instead of the individual messages
+type StatusTracker struct {
+	mu       sync.Mutex
+	channels map[string]map[string]statusEntry
+}
+
+// Status is the tracker which is updated by all modules
+var Status = &StatusTracker{channels: map[string]map[string]statusEntry{}}
+
+// StatusSummary describes the current problems of a channel
+type StatusSummary struct {
+	AlertsFiring     int
+	HostsDown        int
+	ServicesCritical int
+	ServicesWarning  int
+	ServicesUnknown  int
+	PipelinesFailed  int
+
+	Alerts    []string
+	Hosts     []string
+	Services  []string
+	Pipelines []string
+}
+
+func (s *StatusTracker) set(channel string, e statusEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel = strings.ToLower(channel)
+	if _, ok := s.channels[channel]; !ok {
+		s.channels[channel] = map[string]statusEntry{}
+	}
+	s.channels[channel][e.Kind+"/"+e.Name] = e
+}
+
+func (s *StatusTracker) clear(channel string, kind string, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.channels[strings.ToLower(channel)], kind+"/"+name)
+}
+
+// Summary returns the current problems of a channel
+func (s *StatusTracker) Summary(channel string) StatusSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var summary StatusSummary
+	for _, e := range s.channels[strings.ToLower(channel)] {
+		switch e.Kind {
+		case kindAlert:
+			summary.AlertsFiring++
+			summary.Alerts = append(summary.Alerts, e.Name)
+		case kindHost:
+			summary.HostsDown++
+			summary.Hosts = append(summary.Hosts, e.Name)
+		case kindService:
+			switch e.State {
+			case "CRITICAL":
+				summary.ServicesCritical++
+			case "WARNING":
+				summary.ServicesWarning++
+			default:
+				summary.ServicesUnknown++
+			}
+			summary.Services = append(summary.Services, e.Name)
+		case kindPipeline:
+			summary.PipelinesFailed++
+			summary.Pipelines = append(summary.Pipelines, e.Name)
+		}
+	}
+
+	sort.Strings(summary.Alerts)
+	sort.Strings(summary.Hosts)
+	sort.Strings(summary.Services)
+	sort.Strings(summary.Pipelines)
+	return summary
+}
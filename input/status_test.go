@@ -0,0 +1,52 @@
+package input
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/viper"
+)
+
+func TestStatusTracking(t *testing.T) {
+	viper.SetConfigName("testconfig")
+	viper.AddConfigPath(".")
+	err := viper.ReadInConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	file, e := os.Open("./test_data/icinga2.json")
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	req, err := http.NewRequest("POST", "/", file)
+	req.Header.Set("Content-Type", "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	var icinga2Module Module = &Icinga2Module{}
+	c := make(chan IRCMessage, 10)
+	icinga2Module.Init(viper.Sub("modules.icinga2"), &c)
+	handler := http.HandlerFunc(icinga2Module.GetHandler())
+
+	handler.ServeHTTP(rr, req)
+
+	summary := Status.Summary("#monitoring")
+	if summary.ServicesUnknown != 1 {
+		t.Errorf("Status tracker returned wrong number of unknown services: got %v wanted %v",
+			summary.ServicesUnknown, 1)
+	}
+
+	Status.clear("#monitoring", kindService, "test-service-check on test.host.tld")
+	if summary := Status.Summary("#monitoring"); summary.ServicesUnknown != 0 {
+		t.Errorf("Status tracker didn't clear the service: got %v wanted %v",
+			summary.ServicesUnknown, 0)
+	}
+}
@@ -0,0 +1,457 @@
+package input
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var keyRunes = []rune("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+// storeEntry is a single value in a fileStore. The file of a store is a journal with
+// one entry per line, later entries replace earlier ones with the same key.
+type storeEntry struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// fileStore keeps values under short random keys until they expire. When a path is
+// given, the values are persisted in a file and survive a restart. New values are
+// appended to the file, it is only rewritten when expired values are removed.
+type fileStore struct {
+	path      string
+	ttl       time.Duration
+	keyLength int
+
+	mu      sync.Mutex
+	entries map[string]storeEntry
+	// keys finds the key of a value for reuse
+	keys    map[string]string
+	journal *os.File
+	done    chan struct{}
+}
+
+func newFileStore(path string, ttl time.Duration, keyLength int) (*fileStore, error) {
+	s := &fileStore{
+		path:      path,
+		ttl:       ttl,
+		keyLength: keyLength,
+		entries:   map[string]storeEntry{},
+		keys:      map[string]string{},
+		done:      make(chan struct{}),
+	}
+
+	if path != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		// Start with a compact file without the values which expired while we were down
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.cleanup()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// load reads the entries from the file
+func (s *fileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var entry storeEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().After(entry.Expires) {
+			delete(s.entries, entry.Key)
+			continue
+		}
+		s.entries[entry.Key] = entry
+		s.keys[entry.Value] = entry.Key
+	}
+}
+
+// Close stops the cleanup and closes the file of the store
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.done)
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.Close()
+	s.journal = nil
+	return err
+}
+
+// put stores the value and returns its key. When reuse is set and the value is
+// already stored, the existing key is returned and its expiry is extended.
+func (s *fileStore) put(value string, reuse bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(s.ttl)
+	if key, ok := s.keys[value]; ok && reuse && time.Now().Before(s.entries[key].Expires) {
+		entry := storeEntry{Key: key, Value: value, Expires: expires}
+		s.entries[key] = entry
+		return key, s.append(entry)
+	}
+
+	var key string
+	for key == "" || s.entries[key].Value != "" {
+		k, err := randomKey(s.keyLength)
+		if err != nil {
+			return "", err
+		}
+		key = k
+	}
+	entry := storeEntry{Key: key, Value: value, Expires: expires}
+	s.entries[key] = entry
+	s.keys[value] = key
+	return key, s.append(entry)
+}
+
+// get returns the value stored under key, if it didn't expire yet
+func (s *fileStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.Expires) {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+// size returns the total length of all values which didn't expire yet
+func (s *fileStore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, entry := range s.entries {
+		if time.Now().Before(entry.Expires) {
+			total += len(entry.Value)
+		}
+	}
+	return total
+}
+
+func (s *fileStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := false
+	for key, entry := range s.entries {
+		if time.Now().After(entry.Expires) {
+			delete(s.entries, key)
+			if s.keys[entry.Value] == key {
+				delete(s.keys, entry.Value)
+			}
+			removed = true
+		}
+	}
+	if removed {
+		if err := s.save(); err != nil {
+			log.Errorf("Failed to save store %q: %s", s.path, err)
+		}
+	}
+}
+
+// append adds an entry to the end of the file. The caller has to hold the lock.
+func (s *fileStore) append(entry storeEntry) error {
+	if s.journal == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.journal.Write(append(data, '\n'))
+	return err
+}
+
+// save replaces the file with one containing only the current entries and opens it
+// for appending. The caller has to hold the lock.
+func (s *fileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	// Write to a temporary file first, so a crash doesn't leave a half written store
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, entry := range s.entries {
+		if err = encoder.Encode(entry); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.journal, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0)
+	return err
+}
+
+// dirEntry describes a value of a dirStore, the value itself is only kept in its file
+type dirEntry struct {
+	hash    string
+	size    int
+	expires time.Time
+}
+
+// dirStore keeps large values, like pastes, in a directory with one file per value.
+// A value expires ttl after the modification time of its file.
+type dirStore struct {
+	dir       string
+	ttl       time.Duration
+	keyLength int
+
+	mu    sync.Mutex
+	files map[string]dirEntry
+	// keys finds the key of a value by its hash for reuse
+	keys  map[string]string
+	total int
+	done  chan struct{}
+}
+
+func newDirStore(dir string, ttl time.Duration, keyLength int) (*dirStore, error) {
+	s := &dirStore{
+		dir:       dir,
+		ttl:       ttl,
+		keyLength: keyLength,
+		files:     map[string]dirEntry{},
+		keys:      map[string]string{},
+		done:      make(chan struct{}),
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.cleanup()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.cleanup()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// load indexes the files in the directory. Other files, like temporary files of a
+// crashed write, are ignored.
+func (s *dirStore) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		key := file.Name()
+		if file.IsDir() || !validKey(key, s.keyLength) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, key))
+		if err != nil {
+			return err
+		}
+		hash := valueHash(string(data))
+		s.files[key] = dirEntry{hash: hash, size: len(data), expires: info.ModTime().Add(s.ttl)}
+		s.keys[hash] = key
+		s.total += len(data)
+	}
+	return nil
+}
+
+// Close stops the cleanup
+func (s *dirStore) Close() error {
+	close(s.done)
+	return nil
+}
+
+// put stores the value and returns its key. When reuse is set and the value is
+// already stored, the existing key is returned and its expiry is extended.
+func (s *dirStore) put(value string, reuse bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	hash := valueHash(value)
+	if key, ok := s.keys[hash]; ok && reuse && now.Before(s.files[key].expires) {
+		if err := os.Chtimes(filepath.Join(s.dir, key), now, now); err != nil {
+			return "", err
+		}
+		entry := s.files[key]
+		entry.expires = now.Add(s.ttl)
+		s.files[key] = entry
+		return key, nil
+	}
+
+	var key string
+	for key == "" || s.files[key].hash != "" {
+		k, err := randomKey(s.keyLength)
+		if err != nil {
+			return "", err
+		}
+		key = k
+	}
+	// Write to a temporary file first, so a crash doesn't leave a half written value
+	tmp, err := os.CreateTemp(s.dir, ".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, key)); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	s.files[key] = dirEntry{hash: hash, size: len(value), expires: now.Add(s.ttl)}
+	s.keys[hash] = key
+	s.total += len(value)
+	return key, nil
+}
+
+// get returns the value stored under key, if it didn't expire yet
+func (s *dirStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	entry, ok := s.files[key]
+	s.mu.Unlock()
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, key))
+	if err != nil {
+		log.Errorf("Failed to read %q from store %q: %s", key, s.dir, err)
+		return "", false
+	}
+	return string(data), true
+}
+
+// size returns the total length of all stored values
+func (s *dirStore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+func (s *dirStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, entry := range s.files {
+		if time.Now().Before(entry.expires) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, key)); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to remove %q from store %q: %s", key, s.dir, err)
+			continue
+		}
+		delete(s.files, key)
+		if s.keys[entry.hash] == key {
+			delete(s.keys, entry.hash)
+		}
+		s.total -= entry.size
+	}
+}
+
+func valueHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// validKey reports if name can be a key generated by randomKey
+func validKey(name string, length int) bool {
+	if len(name) != length {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune(string(keyRunes), r) {
+			return false
+		}
+	}
+	return true
+}
+
+func randomKey(length int) (string, error) {
+	b := make([]rune, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(keyRunes))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = keyRunes[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,106 @@
+package input
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := newFileStore(path, time.Hour, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := s.put("first", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.put("first", true); again != key {
+		t.Errorf("Store returned a new key %q for a stored value, wanted %q", again, key)
+	}
+	if other, _ := s.put("first", false); other == key {
+		t.Errorf("Store reused the key %q without reuse", key)
+	}
+	secondKey, _ := s.put("second", true)
+
+	// Every put only appends a line to the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 4 {
+		t.Errorf("Store file has %d lines after 4 puts, wanted 4", lines)
+	}
+
+	// Expired values are removed from the file
+	s.mu.Lock()
+	s.entries[key] = storeEntry{Key: key, Value: "first", Expires: time.Now().Add(-time.Minute)}
+	s.mu.Unlock()
+	s.cleanup()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := newFileStore(path, time.Hour, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if _, ok := restarted.get(key); ok {
+		t.Errorf("Expired value is still stored after a restart")
+	}
+	if len(restarted.entries) != 2 {
+		t.Errorf("Store has %d values after a restart, wanted 2", len(restarted.entries))
+	}
+	if second, _ := restarted.put("second", true); second != secondKey {
+		t.Errorf("Store returned a new key %q after a restart, wanted %q", second, secondKey)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newDirStore(dir, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := s.put("first paste", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.put("first paste", true); again != key {
+		t.Errorf("Store returned a new key %q for a stored value, wanted %q", again, key)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, key)); err != nil || string(data) != "first paste" {
+		t.Errorf("File of the value contains %q (%v)", data, err)
+	}
+	expired, _ := s.put("second paste", true)
+	s.Close()
+
+	// Files which expired while we were down are removed on start
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, expired), old, old)
+	os.WriteFile(filepath.Join(dir, ".tmp123"), []byte("half written"), 0600)
+
+	restarted, err := newDirStore(dir, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if value, ok := restarted.get(key); !ok || value != "first paste" {
+		t.Errorf("Store returned %q (%v) after a restart", value, ok)
+	}
+	if again, _ := restarted.put("first paste", true); again != key {
+		t.Errorf("Store returned a new key %q after a restart, wanted %q", again, key)
+	}
+	if _, err := os.Stat(filepath.Join(dir, expired)); !os.IsNotExist(err) {
+		t.Errorf("File of an expired value wasn't removed: %v", err)
+	}
+	if size := restarted.size(); size != len("first paste") {
+		t.Errorf("Store has a size of %d, wanted %d", size, len("first paste"))
+	}
+}
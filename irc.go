@@ -3,27 +3,84 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/fleaz/CptHook/input"
 	"github.com/lrstanley/girc"
 	"github.com/spf13/viper"
 )
 
 var client *girc.Client
 
+// channelConfig holds the optional per-channel settings from the irc.channels block
+type channelConfig struct {
+	Colors      *bool        `mapstructure:"colors"`
+	Topic       *topicConfig `mapstructure:"topic"`
+	MessageType string       `mapstructure:"message_type"`
+}
+
+var channelConfigs = map[string]channelConfig{}
+var plainText bool
+
 func ircConnection(config *viper.Viper, channelList []string) {
+	config.SetDefault("username", config.GetString("nickname"))
+	config.SetDefault("realname", config.GetString("nickname"))
+
 	clientConfig := girc.Config{
-		Server:    config.GetString("host"),
-		Port:      config.GetInt("port"),
-		Nick:      config.GetString("nickname"),
-		User:      config.GetString("nickname"),
-		PingDelay: 30 * time.Second,
+		Server:     config.GetString("host"),
+		Port:       config.GetInt("port"),
+		Nick:       config.GetString("nickname"),
+		User:       config.GetString("username"),
+		Name:       config.GetString("realname"),
+		ServerPass: config.GetString("server_password"),
+		PingDelay:  30 * time.Second,
+		SupportedCaps: map[string][]string{
+			multilineCap:   nil,
+			"echo-message": nil,
+		},
+	}
+
+	plainText = config.GetBool("plain_text")
+	if plainText {
+		log.Info("Plain text mode enabled. All formatting will be stripped from messages")
+	}
+
+	// use_notice is the old way to send all messages as NOTICE
+	if config.GetBool("use_notice") {
+		defaultMessageType = messageTypeNotice
+	}
+	if t := config.GetString("message_type"); t != "" {
+		if err := validateMessageType(t); err != nil {
+			log.Fatalf("Invalid IRC configuration: %s", err)
+		}
+		defaultMessageType = t
+	}
+
+	var boards []*topicBoard
+	var channels map[string]channelConfig
+	if err := config.UnmarshalKey("channels", &channels); err != nil {
+		log.Fatalf("Failed to unmarshal channel configuration: %s", err)
+	}
+	for name, c := range channels {
+		if err := validateMessageType(c.MessageType); err != nil {
+			log.Fatalf("Invalid configuration for channel %q: %s", name, err)
+		}
+		channelConfigs[girc.ToRFC1459(name)] = c
+
+		if c.Topic != nil {
+			board, err := newTopicBoard(name, *c.Topic)
+			if err != nil {
+				log.Fatalf("Invalid topic configuration for channel %q: %s", name, err)
+			}
+			channelList = append(channelList, name)
+			boards = append(boards, board)
+		}
 	}
 
 	if config.IsSet("auth") {
@@ -42,8 +99,15 @@ func ircConnection(config *viper.Viper, channelList []string) {
 				Identity: auth.GetString("identity"),
 			}
 
+		case "SASL-SCRAM-SHA-256", "SASL-SCRAM-SHA-512":
+			scram, err := newSASLSCRAM(strings.TrimPrefix(auth.GetString("method"), "SASL-"), auth.GetString("username"), auth.GetString("password"))
+			if err != nil {
+				log.Fatalf("Invalid auth configuration: %s", err)
+			}
+			clientConfig.SASL = scram
+
 		default:
-			panic("Unsupported authentication method")
+			log.Fatalf("Unsupported authentication method %q. Use SASL-Plain, SASL-External, SASL-SCRAM-SHA-256 or SASL-SCRAM-SHA-512", auth.GetString("method"))
 		}
 	}
 
@@ -87,45 +151,77 @@ func ircConnection(config *viper.Viper, channelList []string) {
 
 	client = girc.New(clientConfig)
 
+	for _, board := range boards {
+		go board.run()
+	}
+
+	config.SetDefault("ison_interval", 60*time.Second)
+	presence.register(client, config.GetDuration("ison_interval"))
+
+	config.SetDefault("direct_messages.require_online", false)
+	direct.configure(config.Sub("direct_messages"))
+
+	if config.IsSet("nickserv") {
+		log.Info("Configuring NickServ for IRC connection")
+		ns, err := newNickServ(config.Sub("nickserv"), clientConfig.Nick, clientConfig.SASL != nil)
+		if err != nil {
+			log.Fatalf("Invalid NickServ configuration: %s", err)
+		}
+		ns.register(client)
+	}
+
+	client.Handlers.Add(girc.CAP, handleMultilineCap)
+	client.Handlers.Add(girc.ALL_EVENTS, handleEcho)
+	client.Handlers.Add("BATCH", handleBatchEcho)
+
+	config.SetDefault("buffer.size", 500)
+	outage.configure(config.Sub("buffer"))
+
+	config.SetDefault("delivery.retries", 2)
+	deliveries.configure(config.Sub("delivery"))
+
 	client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
 		log.Info("Sucessfully connected to the IRC server. Starting to join channel.")
 		for _, name := range removeDuplicates(channelList) {
 			joinChannel(name)
 		}
+		outage.setConnected(true)
 	})
 
-	client.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
-		if e.IsFromUser() {
-			log.WithFields(log.Fields{
-				"Event": e.String(),
-			}).Debug("Received a PRIMSG")
-			message := "Hi. I'm a CptHook bot. Visit https://github.com/fleaz/CptHook to learn more."
-			if version == "dev" {
-				message += fmt.Sprintf(" I was compiled by hand at %v", date)
-			} else {
-				message += fmt.Sprintf(" I am running v%v (Commit: %v, Builddate: %v)", version, commit, date)
-			}
-			c.Cmd.ReplyTo(e, message)
-		}
-	})
+	// Answers to private messages and CTCP requests are rate limited per user
+	config.SetDefault("query_reply.rate_limit", 10*time.Minute)
+	replyLimiter := newRateLimiter(config.GetDuration("query_reply.rate_limit"))
+	registerCTCP(client, replyLimiter)
 
-	// Start thread to process message queue
-	go channelReceiver(config.GetBool("use_notice"))
+	config.SetDefault("commands.enabled", false)
+	if err := commands.configure(config.Sub("commands")); err != nil {
+		log.Fatalf("Invalid commands configuration: %s", err)
+	}
 
-	log.Info("Connecting to IRC server")
-	for {
-		// client.Connect() blocks while we are connected.
-		// If the the connection is dropped/broken (recognized if we don't get a PONG 30 seconds
-		// after we sent a PING) an error is returned.
-		err := client.Connect()
-		// If we manually Close() the connection, the Connect() function will exit without an error
+	config.SetDefault("query_reply.enabled", true)
+	var reply *queryReply
+	if config.GetBool("query_reply.enabled") {
+		var err error
+		reply, err = newQueryReply(config.Sub("query_reply"), replyLimiter)
 		if err != nil {
-			log.Warnf("Connection terminated. Reason: %s\n", err)
+			log.Fatalf("Invalid query_reply configuration: %s", err)
 		}
-		log.Warn("Reconnecting in 10 seconds...")
-		time.Sleep(10 * time.Second)
+	} else {
+		log.Info("Replies to private messages are disabled")
 	}
+	client.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+		if commands.handle(c, e) {
+			return
+		}
+		if reply != nil {
+			reply.handle(c, e)
+		}
+	})
 
+	// Start thread to process message queue
+	go channelReceiver()
+
+	connectLoop(config)
 }
 
 func contains(e string, slice []string) bool {
@@ -147,27 +243,150 @@ func removeDuplicates(input []string) []string {
 	return output
 }
 
-func channelReceiver(useNotice bool) {
+func channelReceiver() {
 	log.Info("ChannelReceiver started")
 
-	for elem := range inputChannel {
-		log.WithFields(log.Fields{
-			"MsgID":   elem.ID,
-			"text":    elem.Messages,
-			"channel": elem.Channel,
-		}).Debug("IRC handler received a message")
-		joinChannel(elem.Channel)
-		for _, message := range elem.Messages {
-			if useNotice {
-				client.Cmd.Notice(elem.Channel, message)
-			} else {
-				client.Cmd.Message(elem.Channel, message)
+	for {
+		select {
+		case elem := <-inputChannel:
+			log.WithFields(log.Fields{
+				"MsgID":   elem.ID,
+				"text":    elem.Messages,
+				"channel": elem.Channel,
+			}).Debug("IRC handler received a message")
+			if !outage.hold(elem) {
+				deliver(elem)
+			}
+		case <-outage.reconnected:
+			for _, elem := range outage.drain() {
+				deliver(elem)
 			}
 		}
 	}
 }
 
+// deliver sends a message to its channel or user
+func deliver(elem input.IRCMessage) {
+	original := elem
+	if isUserTarget(elem.Channel) {
+		if !direct.prepare(&elem) {
+			return
+		}
+	} else {
+		joinChannel(elem.Channel)
+	}
+	strip := stripFormatting(elem.Channel, client.LookupChannel(elem.Channel))
+	var lines []string
+	for _, message := range elem.Messages {
+		if strip {
+			message = girc.StripRaw(message)
+		}
+		lines = append(lines, message)
+	}
+
+	msgType := messageType(elem)
+	if msgType == messageTypeAction {
+		for i, line := range lines {
+			lines[i] = actionLine(line)
+		}
+	}
+
+	// A multiline batch is echoed as a whole, otherwise every line is echoed. Long
+	// messages are split into several batches.
+	batch := len(lines) > 1 && client.HasCapability(multilineCap) && msgType != messageTypeAction
+	var units [][]string
+	if batch {
+		units = multilineBatches(lines)
+	} else {
+		for _, line := range lines {
+			units = append(units, []string{line})
+		}
+	}
+	send := deliveries.track(original, len(units), confirmable())
+
+	sendUnits(messageCommand(msgType), elem, units, send, batch)
+}
+
+// sendUnits sends the given units of a message, either as multiline batches or line by
+// line. When message tags are supported, the lines are tagged with the metadata of the
+// event and the number of their unit, and follow-up lines are marked as reply to the
+// first line. Actions are never batched, because CTCP can't span multiple lines.
+func sendUnits(command string, elem input.IRCMessage, units [][]string, send []int, batch bool) {
+	if len(send) == 0 {
+		return
+	}
+	var tags girc.Tags
+	if client.HasCapability("message-tags") {
+		tags = messageTags(elem)
+	}
+	unitTags := func(unit int, reply string) girc.Tags {
+		if tags == nil {
+			return nil
+		}
+		t := girc.Tags{}
+		for k, v := range tags {
+			t[k] = v
+		}
+		t.Set(tagPart, strconv.Itoa(unit))
+		if reply != "" {
+			t.Set(tagReply, reply)
+		}
+		return t
+	}
+
+	if batch {
+		for _, unit := range send {
+			sendMultiline(command, elem.Channel, elem.ID+strconv.Itoa(unit), units[unit], unitTags(unit, ""))
+		}
+		return
+	}
+
+	sendLine := func(unit int, reply string) {
+		client.Send(&girc.Event{Tags: unitTags(unit, reply), Command: command, Params: []string{elem.Channel, units[unit][0]}})
+	}
+	if send[0] != 0 || len(send) == 1 || tags == nil || !client.HasCapability("echo-message") {
+		for _, unit := range send {
+			sendLine(unit, "")
+		}
+		return
+	}
+
+	// The follow-up lines wait for the msgid of the first line, without blocking the
+	// delivery of other messages
+	waiter := awaitEcho(elem.ID)
+	sendLine(0, "")
+	go func() {
+		reply := waitForMsgID(elem.ID, waiter)
+		for _, unit := range send[1:] {
+			sendLine(unit, reply)
+		}
+	}()
+}
+
+// stripFormatting reports whether colors and other formatting codes have to be removed
+// before sending to a target. This is the case in plain text mode, when the channel is
+// explicitly configured with colors disabled or when the channel has mode +c set. As long
+// as the modes of a channel are unknown, e.g. right after joining it, +c is assumed.
+func stripFormatting(target string, channel *girc.Channel) bool {
+	if plainText {
+		return true
+	}
+	if c, ok := channelConfigs[girc.ToRFC1459(target)]; ok && c.Colors != nil && !*c.Colors {
+		return true
+	}
+	if !girc.IsValidChannel(target) {
+		return false
+	}
+	// Every channel has some modes, they are just not received yet
+	return channel == nil || channel.Modes.String() == "" || channel.Modes.HasMode("c")
+}
+
 func joinChannel(newChannel string) {
+	if isUserTarget(newChannel) {
+		// Messages to users are sent by query and don't need a JOIN
+		return
+	}
+
 	for _, channelName := range client.ChannelList() {
 		if strings.Compare(newChannel, channelName) == 0 {
 			return
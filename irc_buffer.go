@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+const (
+	policyDropOldest = "drop_oldest"
+	policyDropNewest = "drop_newest"
+	policyReject     = "reject"
+)
+
+type bufferedMessage struct {
+	message input.IRCMessage
+	// late is set for messages which arrived while we were disconnected. Messages which
+	// only wait for older messages after a reconnect are not late.
+	late bool
+}
+
+// outageBuffer holds messages while we are not connected to the IRC server
+type outageBuffer struct {
+	size     int
+	policy   string
+	markLate bool
+
+	mu        sync.Mutex
+	connected bool
+	messages  []bufferedMessage
+
+	// reconnected is signaled after we are connected again and joined all channels
+	reconnected chan struct{}
+}
+
+var outage = &outageBuffer{
+	size:        500,
+	policy:      policyDropOldest,
+	markLate:    true,
+	reconnected: make(chan struct{}, 1),
+}
+
+func (b *outageBuffer) configure(config *viper.Viper) {
+	config.SetDefault("size", 500)
+	config.SetDefault("policy", policyDropOldest)
+	config.SetDefault("mark_late", true)
+
+	b.size = config.GetInt("size")
+	b.policy = config.GetString("policy")
+	b.markLate = config.GetBool("mark_late")
+
+	switch b.policy {
+	case policyDropOldest, policyDropNewest, policyReject:
+	default:
+		log.Fatalf("Unknown buffer policy %q", b.policy)
+	}
+
+	client.Handlers.Add(girc.DISCONNECTED, func(c *girc.Client, e girc.Event) {
+		b.setConnected(false)
+	})
+}
+
+func (b *outageBuffer) setConnected(connected bool) {
+	b.mu.Lock()
+	b.connected = connected
+	b.mu.Unlock()
+
+	if connected {
+		select {
+		case b.reconnected <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// accepts reports if new messages can be delivered right away or buffered
+func (b *outageBuffer) accepts() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connected || b.policy != policyReject || len(b.messages) < b.size
+}
+
+// hold returns true and buffers the message when it can't be delivered right now.
+// Messages are also buffered while older messages wait, so the order is kept.
+func (b *outageBuffer) hold(elem input.IRCMessage) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.connected && len(b.messages) == 0 {
+		return false
+	}
+
+	if len(b.messages) >= b.size {
+		switch b.policy {
+		case policyDropOldest:
+			log.WithFields(log.Fields{
+				"MsgID": b.messages[0].message.ID,
+			}).Warn("Outage buffer is full. Dropping oldest message")
+			deliveries.drop(b.messages[0].message)
+			b.messages = b.messages[1:]
+		default:
+			log.WithFields(log.Fields{
+				"MsgID": elem.ID,
+			}).Warn("Outage buffer is full. Dropping message")
+			deliveries.drop(elem)
+			return true
+		}
+	}
+
+	if b.connected {
+		log.WithFields(log.Fields{
+			"MsgID": elem.ID,
+		}).Debug("Buffering message until the buffered messages are delivered")
+	} else {
+		log.WithFields(log.Fields{
+			"MsgID": elem.ID,
+		}).Info("IRC server is disconnected. Buffering message")
+	}
+	b.messages = append(b.messages, bufferedMessage{message: elem, late: !b.connected})
+	return true
+}
+
+// drain returns all buffered messages and empties the buffer. The first line of each
+// message which arrived while we were disconnected is marked as late, if enabled.
+func (b *outageBuffer) drain() []input.IRCMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []input.IRCMessage
+	for _, m := range b.messages {
+		elem := m.message
+		if b.markLate && m.late && len(elem.Messages) > 0 {
+			lines := append([]string{}, elem.Messages...)
+			lines[0] += fmt.Sprintf(" (delivered late, originally at %s)", elem.Received.Format("15:04"))
+			elem.Messages = lines
+		}
+		messages = append(messages, elem)
+	}
+	b.messages = nil
+
+	if len(messages) > 0 {
+		log.Infof("Delivering %d buffered messages", len(messages))
+	}
+	return messages
+}
+
+// bufferCheckMiddleware rejects webhooks while we are disconnected and the buffer is
+// full, so the sender knows it has to retry later
+func bufferCheckMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !outage.accepts() {
+			log.WithFields(log.Fields{
+				"remote": r.RemoteAddr,
+				"uri":    r.URL,
+			}).Warn("IRC server is disconnected and the buffer is full. Rejecting incoming HTTP request")
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("IRC server disconnected"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+)
+
+func TestOutageBufferDrops(t *testing.T) {
+	for _, test := range []struct {
+		policy  string
+		held    []string
+		dropped string
+		kept    string
+	}{
+		{policyDropOldest, []string{"AAAAAA", "BBBBBB"}, "AAAAAA", "BBBBBB"},
+		{policyDropNewest, []string{"CCCCCC", "DDDDDD"}, "DDDDDD", "CCCCCC"},
+	} {
+		b := &outageBuffer{size: 1, policy: test.policy}
+
+		for _, id := range test.held {
+			if !b.hold(input.IRCMessage{ID: id, Channel: "#test", Messages: []string{"Test"}}) {
+				t.Fatalf("Message %s wasn't held while disconnected", id)
+			}
+		}
+
+		if len(b.messages) != 1 || b.messages[0].message.ID != test.kept {
+			t.Errorf("Buffer with %s holds %v, wanted %s", test.policy, b.messages, test.kept)
+		}
+		if d, ok := deliveries.get(test.dropped); !ok || d.State != deliveryDropped {
+			t.Errorf("Message dropped by %s wasn't reported as dropped", test.policy)
+		}
+		if _, ok := deliveries.get(test.kept); ok {
+			t.Errorf("Buffered message was reported with %s", test.policy)
+		}
+	}
+}
+
+func TestOutageBufferRejects(t *testing.T) {
+	b := &outageBuffer{size: 1, policy: policyReject}
+	if !b.accepts() {
+		t.Error("Empty buffer doesn't accept messages")
+	}
+	b.hold(input.IRCMessage{ID: "AAAAAA", Channel: "#test", Messages: []string{"Test"}})
+	if b.accepts() {
+		t.Error("Full buffer accepts messages while disconnected")
+	}
+	b.setConnected(true)
+	if !b.accepts() {
+		t.Error("Full buffer doesn't accept messages while connected")
+	}
+}
+
+func TestOutageBufferMarksLate(t *testing.T) {
+	b := &outageBuffer{size: 10, policy: policyDropOldest, markLate: true}
+	received := time.Date(2024, 5, 1, 13, 37, 0, 0, time.Local)
+
+	b.hold(input.IRCMessage{ID: "AAAAAA", Messages: []string{"Disconnected"}, Received: received})
+	b.setConnected(true)
+	// Arrives after the reconnect, but before the buffered messages are delivered
+	if !b.hold(input.IRCMessage{ID: "BBBBBB", Messages: []string{"Connected"}, Received: received}) {
+		t.Fatal("Message wasn't held while older messages wait")
+	}
+
+	messages := b.drain()
+	if len(messages) != 2 {
+		t.Fatalf("Buffer returned %d messages, wanted 2", len(messages))
+	}
+	if want := "Disconnected (delivered late, originally at 13:37)"; messages[0].Messages[0] != want {
+		t.Errorf("Message buffered while disconnected is %q, wanted %q", messages[0].Messages[0], want)
+	}
+	if messages[1].Messages[0] != "Connected" {
+		t.Errorf("Message buffered while connected was marked as late: %q", messages[1].Messages[0])
+	}
+	if b.hold(input.IRCMessage{ID: "CCCCCC"}) {
+		t.Error("Message was held although the buffer is empty and we are connected")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+const (
+	// commandReplyChannel answers in the channel the command was sent in
+	commandReplyChannel = "channel"
+	// commandReplyQuery always answers by query
+	commandReplyQuery = "query"
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// commandACL restricts who may use a command. Users have to match one of the entries.
+// An empty ACL allows everybody who is in the channel the command reads.
+type commandACL struct {
+	// Accounts are services accounts, which are known with the IRCv3 account-tag,
+	// account-notify and extended-join capabilities
+	Accounts  []string `mapstructure:"accounts"`
+	Hostmasks []string `mapstructure:"hostmasks"`
+	// Ops allows operators of the channel the command reads
+	Ops bool `mapstructure:"ops"`
+}
+
+// allows checks the sender of a command. channel is the channel the command reads or,
+// if it doesn't read one, the channel it was sent in. It is empty for queries.
+func (a commandACL) allows(c *girc.Client, e girc.Event, account, channel string) bool {
+	for _, allowed := range a.Accounts {
+		if account != "" && strings.EqualFold(allowed, account) {
+			return true
+		}
+	}
+	source := strings.ToLower(e.Source.String())
+	for _, mask := range a.Hostmasks {
+		if girc.Glob(source, strings.ToLower(mask)) {
+			return true
+		}
+	}
+
+	user := c.LookupUser(e.Source.Name)
+	if a.Ops && channel != "" && user != nil {
+		if perms, ok := user.Perms.Lookup(channel); ok && perms.IsAdmin() {
+			return true
+		}
+	}
+	if len(a.Accounts) == 0 && len(a.Hostmasks) == 0 && !a.Ops {
+		// Nobody can read a channel they aren't in
+		return channel == "" || (user != nil && user.InChannel(channel))
+	}
+	return false
+}
+
+// registeredCommand is a command together with the name of the block which provides it
+type registeredCommand struct {
+	input.Command
+	block string
+}
+
+// qualifiedName is the name of the command which is unique even when several blocks
+// provide a command with the same name
+func (r registeredCommand) qualifiedName() string {
+	if r.block == "" {
+		return r.Name
+	}
+	return r.block + "." + r.Name
+}
+
+// commandRegistry parses commands sent to CptHook and runs the commands of the modules
+type commandRegistry struct {
+	enabled bool
+	prefix  string
+	reply   string
+	acl     commandACL
+	acls    map[string]commandACL
+	limiter *rateLimiter
+
+	commands []registeredCommand
+}
+
+var commands = &commandRegistry{}
+
+// register adds the commands of a module block
+func (r *commandRegistry) register(block string, cmds []input.Command) {
+	for _, cmd := range cmds {
+		r.commands = append(r.commands, registeredCommand{Command: cmd, block: block})
+	}
+}
+
+func (r *commandRegistry) configure(config *viper.Viper) error {
+	config.SetDefault("prefix", "!")
+	config.SetDefault("reply", commandReplyChannel)
+	config.SetDefault("rate_limit", 3*time.Second)
+
+	r.enabled = config.GetBool("enabled")
+	r.prefix = config.GetString("prefix")
+	r.reply = config.GetString("reply")
+	r.limiter = newRateLimiter(config.GetDuration("rate_limit"))
+
+	if r.reply != commandReplyChannel && r.reply != commandReplyQuery {
+		return fmt.Errorf("reply must be %s or %s", commandReplyChannel, commandReplyQuery)
+	}
+	if err := config.UnmarshalKey("acl", &r.acl); err != nil {
+		return err
+	}
+	if err := config.UnmarshalKey("acls", &r.acls); err != nil {
+		return err
+	}
+
+	r.register("", []input.Command{{
+		Name:    "help",
+		Usage:   "[command]",
+		Help:    "Lists the commands or shows the usage of a command",
+		MaxArgs: 1,
+		Run:     r.help,
+	}})
+	return nil
+}
+
+// aclFor returns the ACL of a command. An ACL for the qualified name, e.g.
+// "gitlab-ci.pipelines", takes precedence over one for the plain name of the command.
+func (r *commandRegistry) aclFor(cmd registeredCommand) commandACL {
+	// viper lowercases all keys
+	if acl, ok := r.acls[strings.ToLower(cmd.qualifiedName())]; ok {
+		return acl
+	}
+	if acl, ok := r.acls[strings.ToLower(cmd.Name)]; ok {
+		return acl
+	}
+	return r.acl
+}
+
+// help is the built-in help command
+func (r *commandRegistry) help(ctx input.CommandContext) ([]string, error) {
+	if len(ctx.Args) == 1 {
+		cmd, err := r.lookup(ctx.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("%s%s %s - %s", r.prefix, ctx.Args[0], cmd.Usage, cmd.Help)}, nil
+	}
+
+	var names []string
+	for _, cmd := range r.commands {
+		if _, err := r.lookup(cmd.Name); err == nil {
+			names = append(names, cmd.Name)
+		} else {
+			names = append(names, cmd.qualifiedName())
+		}
+	}
+	sort.Strings(names)
+	return []string{
+		"Commands: " + strings.Join(names, ", "),
+		fmt.Sprintf("Send %shelp <command> to learn more about a command", r.prefix),
+	}, nil
+}
+
+// lookup finds a command by its name. Commands which are provided by several blocks
+// have to be called by their qualified name, e.g. "gitlab-ci.pipelines".
+func (r *commandRegistry) lookup(name string) (registeredCommand, error) {
+	var found []registeredCommand
+	for _, cmd := range r.commands {
+		if strings.EqualFold(cmd.Name, name) || strings.EqualFold(cmd.qualifiedName(), name) {
+			found = append(found, cmd)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return registeredCommand{}, errUnknownCommand
+	case 1:
+		return found[0], nil
+	default:
+		var names []string
+		for _, cmd := range found {
+			names = append(names, cmd.qualifiedName())
+		}
+		sort.Strings(names)
+		return registeredCommand{}, fmt.Errorf("%s is ambiguous, use one of %s", name, strings.Join(names, ", "))
+	}
+}
+
+// commandLine returns the message without the prefix or the nick of CptHook. explicit
+// is false when the message can only be a command because it was sent by query.
+func (r *commandRegistry) commandLine(c *girc.Client, e girc.Event) (line string, explicit bool) {
+	text := strings.TrimSpace(e.Last())
+	if r.prefix != "" && strings.HasPrefix(text, r.prefix) {
+		return text[len(r.prefix):], true
+	}
+
+	nick := c.GetNick()
+	if len(text) > len(nick) && strings.EqualFold(text[:len(nick)], nick) && strings.ContainsRune(":,", rune(text[len(nick)])) {
+		return text[len(nick)+1:], true
+	}
+
+	if e.IsFromChannel() {
+		return "", false
+	}
+	return text, false
+}
+
+// handle runs the command in a PRIVMSG and reports if the message was a command
+func (r *commandRegistry) handle(c *girc.Client, e girc.Event) bool {
+	if !r.enabled || e.Source == nil || (!e.IsFromChannel() && !e.IsFromUser()) {
+		return false
+	}
+	if ok, _ := e.IsCTCP(); ok {
+		return false
+	}
+
+	line, explicit := r.commandLine(c, e)
+	args, err := input.ParseArguments(line)
+	if len(args) == 0 && err == nil {
+		return explicit
+	}
+
+	var cmd registeredCommand
+	if err == nil {
+		cmd, err = r.lookup(args[0])
+	}
+	if err != nil {
+		if !explicit {
+			// Other private messages are answered by the query reply
+			return false
+		}
+		// Other bots in the channel might use the same prefix
+		if !(e.IsFromChannel() && err == errUnknownCommand) && r.limiter.Allow(e.Source.ID()) {
+			c.Cmd.Notice(e.Source.Name, err.Error())
+		}
+		return true
+	}
+
+	if !r.limiter.Allow(e.Source.ID()) {
+		log.WithFields(log.Fields{
+			"sender":  e.Source.Name,
+			"command": cmd.qualifiedName(),
+		}).Debug("Not running command because of the rate limit")
+		return true
+	}
+
+	ctx := input.CommandContext{
+		Args:    args[1:],
+		Nick:    e.Source.Name,
+		Account: account(c, e),
+	}
+	if e.IsFromChannel() {
+		ctx.Channel = e.Params[0]
+	}
+
+	acl := r.aclFor(cmd)
+	channel := ctx.Channel
+	if cmd.Channel != nil {
+		channel = cmd.Channel(ctx)
+	}
+	if !acl.allows(c, e, ctx.Account, channel) {
+		log.WithFields(log.Fields{
+			"sender":  e.Source.String(),
+			"account": ctx.Account,
+			"channel": channel,
+			"command": cmd.qualifiedName(),
+		}).Warn("Denied command")
+		c.Cmd.Notice(e.Source.Name, fmt.Sprintf("You are not allowed to use %s", cmd.Name))
+		return true
+	}
+
+	if len(ctx.Args) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(ctx.Args) > cmd.MaxArgs) {
+		c.Cmd.Notice(e.Source.Name, fmt.Sprintf("Usage: %s%s %s", r.prefix, args[0], cmd.Usage))
+		return true
+	}
+
+	log.WithFields(log.Fields{
+		"sender":  e.Source.String(),
+		"account": ctx.Account,
+		"channel": ctx.Channel,
+		"command": cmd.qualifiedName(),
+		"args":    ctx.Args,
+	}).Info("Running command")
+
+	lines, err := cmd.Run(ctx)
+	if err != nil {
+		c.Cmd.Notice(e.Source.Name, fmt.Sprintf("%s: %s", cmd.Name, err))
+		return true
+	}
+
+	target := e.Source.Name
+	if ctx.Channel != "" && r.reply == commandReplyChannel {
+		target = ctx.Channel
+	}
+	strip := stripFormatting(target, c.LookupChannel(target))
+	for _, line := range lines {
+		if strip {
+			line = girc.StripRaw(line)
+		}
+		c.Cmd.Message(target, line)
+	}
+	return true
+}
+
+// account returns the services account of the sender of a message, if it is known
+func account(c *girc.Client, e girc.Event) string {
+	if account, ok := e.Tags.Get("account"); ok {
+		return account
+	}
+	if user := c.LookupUser(e.Source.Name); user != nil && user.Extras.Account != "*" {
+		return user.Extras.Account
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+func TestCommandACLs(t *testing.T) {
+	config := viper.New()
+	config.SetConfigType("yaml")
+	err := config.ReadConfig(strings.NewReader(`
+acl:
+    accounts: ["alice"]
+acls:
+    pipelines:
+        accounts: ["bob"]
+    "Gitlab-CI.Pipelines":
+        accounts: ["carol"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &commandRegistry{}
+	if err := r.configure(config); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		block   string
+		name    string
+		account string
+	}{
+		{"gitlab-ci", "pipelines", "carol"},
+		{"gitlab", "pipelines", "bob"},
+		{"gitlab", "Pipelines", "bob"},
+		{"icinga", "status", "alice"},
+	}
+	for _, test := range tests {
+		cmd := registeredCommand{Command: input.Command{Name: test.name}, block: test.block}
+		if acl := r.aclFor(cmd); len(acl.Accounts) != 1 || acl.Accounts[0] != test.account {
+			t.Errorf("Command %s has the ACL %+v, wanted the one of %s", cmd.qualifiedName(), acl, test.account)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+const (
+	// deliveryPending means we are waiting for the echo of the message
+	deliveryPending = "pending"
+	// deliverySent means the message was sent, but the server doesn't support echo-message to confirm it
+	deliverySent = "sent"
+	// deliveryDelivered means the server echoed every line of the message back to us
+	deliveryDelivered = "delivered"
+	// deliveryFailed means the message wasn't confirmed after all retries
+	deliveryFailed = "failed"
+	// deliveryDropped means the message was never sent because a buffer or queue was full
+	deliveryDropped = "dropped"
+)
+
+// delivery is the delivery state of a single IRCMessage
+type delivery struct {
+	ID       string    `json:"id"`
+	Channel  string    `json:"channel"`
+	State    string    `json:"state"`
+	Attempts int       `json:"attempts"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+
+	message input.IRCMessage
+	// confirmed marks the units of the message which the server echoed back
+	confirmed []bool
+	timer     *time.Timer
+}
+
+// setState changes the state of the delivery and reports every new result to the sinks
+func (d *delivery) setState(state string) {
+	if d.State == state {
+		return
+	}
+	d.State = state
+	if state != deliveryPending {
+		sinks.Delivered(d.message, state)
+	}
+}
+
+// deliveryTracker correlates the echoes of sent messages with the messages and retries
+// messages which were not confirmed in time
+type deliveryTracker struct {
+	timeout   time.Duration
+	retries   int
+	retention time.Duration
+
+	mu         sync.Mutex
+	deliveries map[string]*delivery
+}
+
+var deliveries = &deliveryTracker{
+	timeout:    30 * time.Second,
+	retries:    2,
+	retention:  24 * time.Hour,
+	deliveries: map[string]*delivery{},
+}
+
+func (t *deliveryTracker) configure(config *viper.Viper) {
+	config.SetDefault("timeout", 30*time.Second)
+	config.SetDefault("retries", 2)
+	config.SetDefault("retention", 24*time.Hour)
+	t.timeout = config.GetDuration("timeout")
+	t.retries = config.GetInt("retries")
+	t.retention = config.GetDuration("retention")
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			t.cleanup()
+		}
+	}()
+}
+
+// confirmable reports if the server gives us the information to confirm deliveries
+func confirmable() bool {
+	return client.HasCapability("echo-message") && client.HasCapability("message-tags")
+}
+
+// track registers a message which is about to be sent. units is the number of parts
+// which are echoed separately, every line or every multiline batch. It returns the
+// units which have to be sent: all of them the first time, only the unconfirmed ones
+// when the message is sent again.
+func (t *deliveryTracker) track(elem input.IRCMessage, units int, confirmable bool) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.deliveries[elem.ID]
+	if !ok {
+		d = &delivery{
+			ID:      elem.ID,
+			Channel: elem.Channel,
+			Created: time.Now(),
+			message: elem,
+		}
+		t.deliveries[elem.ID] = d
+	}
+	d.Attempts++
+	d.Updated = time.Now()
+	// The message is split differently than before, e.g. after a reconnect to a server
+	// without multiline batches, so the confirmed units don't match anymore
+	if len(d.confirmed) != units {
+		d.confirmed = make([]bool, units)
+	}
+	var send []int
+	for unit, confirmed := range d.confirmed {
+		if !confirmed {
+			send = append(send, unit)
+		}
+	}
+
+	if !confirmable {
+		d.setState(deliverySent)
+		return send
+	}
+
+	d.setState(deliveryPending)
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(t.timeout, func() { t.expire(elem.ID) })
+	return send
+}
+
+// drop records a message which was dropped before it could be sent
+func (t *deliveryTracker) drop(elem input.IRCMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.deliveries[elem.ID]
+	if !ok {
+		d = &delivery{
+			ID:      elem.ID,
+			Channel: elem.Channel,
+			Created: time.Now(),
+			message: elem,
+		}
+		t.deliveries[elem.ID] = d
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.Updated = time.Now()
+	d.setState(deliveryDropped)
+}
+
+// confirm marks a unit of the message with the given ID as echoed. girc splits lines
+// which are too long into several messages, the first echo of them confirms the unit.
+func (t *deliveryTracker) confirm(id string, unit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.deliveries[id]
+	if !ok || d.State != deliveryPending || unit < 0 || unit >= len(d.confirmed) {
+		return
+	}
+	d.confirmed[unit] = true
+	for _, confirmed := range d.confirmed {
+		if !confirmed {
+			return
+		}
+	}
+
+	d.Updated = time.Now()
+	d.timer.Stop()
+	d.setState(deliveryDelivered)
+	log.WithFields(log.Fields{
+		"MsgID":    id,
+		"attempts": d.Attempts,
+	}).Debug("Delivery of message confirmed by the server")
+}
+
+// expire is called when a message wasn't confirmed in time. The message is sent again
+// until the retries are used up.
+func (t *deliveryTracker) expire(id string) {
+	t.mu.Lock()
+	d, ok := t.deliveries[id]
+	if !ok || d.State != deliveryPending {
+		t.mu.Unlock()
+		return
+	}
+	d.Updated = time.Now()
+
+	if d.Attempts > t.retries {
+		d.setState(deliveryFailed)
+		t.mu.Unlock()
+		log.WithFields(log.Fields{
+			"MsgID":    id,
+			"channel":  d.Channel,
+			"attempts": d.Attempts,
+		}).Error("Delivery of message failed")
+		return
+	}
+	elem := d.message
+	t.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"MsgID":   id,
+		"channel": elem.Channel,
+	}).Warn("Delivery of message was not confirmed. Sending the unconfirmed lines again")
+	go func() { inputChannel <- elem }()
+}
+
+func (t *deliveryTracker) cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, d := range t.deliveries {
+		if d.State != deliveryPending && time.Since(d.Updated) > t.retention {
+			delete(t.deliveries, id)
+		}
+	}
+}
+
+// get returns a copy of the delivery state of a message
+func (t *deliveryTracker) get(id string) (delivery, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.deliveries[id]
+	if !ok {
+		return delivery{}, false
+	}
+	return *d, true
+}
+
+// handler returns the delivery state of the message whose ID is the last part of the path
+func (t *deliveryTracker) handler(endpoint string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, endpoint), "/")
+		d, ok := t.get(strings.ToUpper(id))
+		if !ok {
+			http.Error(w, "Unknown message ID", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// handleBatchEcho confirms multiline batches. The messages inside an echoed batch
+// don't carry our tags, only the opening BATCH command does.
+func handleBatchEcho(c *girc.Client, e girc.Event) {
+	if e.Source == nil || e.Source.ID() != c.GetID() || len(e.Params) < 2 {
+		return
+	}
+	if !strings.HasPrefix(e.Params[0], "+") || e.Params[1] != multilineCap {
+		return
+	}
+	if id, unit, ok := echoedUnit(e); ok {
+		deliveries.confirm(id, unit)
+	}
+}
+
+// echoedUnit returns the message ID and the unit of an echo
+func echoedUnit(e girc.Event) (id string, unit int, ok bool) {
+	id, ok = e.Tags.Get(tagID)
+	if !ok {
+		return "", 0, false
+	}
+	part, _ := e.Tags.Get(tagPart)
+	unit, err := strconv.Atoi(part)
+	if err != nil {
+		return "", 0, false
+	}
+	return id, unit, true
+}
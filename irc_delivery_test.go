@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/fleaz/CptHook/output"
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+// resultRecorder records the delivery results reported to the sinks
+type resultRecorder struct {
+	results []string
+}
+
+func (r *resultRecorder) Init(c *viper.Viper) error     { return nil }
+func (r *resultRecorder) Send(message input.IRCMessage) {}
+func (r *resultRecorder) Delivered(message input.IRCMessage, result string) {
+	r.results = append(r.results, message.ID+" "+result)
+}
+
+func recordResults(t *testing.T) *resultRecorder {
+	recorder := &resultRecorder{}
+	previous := sinks
+	sinks = &output.Dispatcher{}
+	sinks.Add("recorder", recorder)
+	t.Cleanup(func() { sinks = previous })
+	return recorder
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	recorder := recordResults(t)
+	tracker := &deliveryTracker{timeout: time.Hour, deliveries: map[string]*delivery{}}
+	elem := input.IRCMessage{ID: "AAAAAA", Channel: "#test", Messages: []string{"one", "two", "three"}}
+
+	if send := tracker.track(elem, 3, true); !reflect.DeepEqual(send, []int{0, 1, 2}) {
+		t.Errorf("First attempt sends units %v, wanted all", send)
+	}
+	tracker.confirm("AAAAAA", 1)
+	tracker.confirm("AAAAAA", 1)
+
+	// A retry only sends the lines which weren't echoed yet
+	if send := tracker.track(elem, 3, true); !reflect.DeepEqual(send, []int{0, 2}) {
+		t.Errorf("Retry sends units %v, wanted [0 2]", send)
+	}
+	tracker.confirm("AAAAAA", 0)
+	tracker.confirm("AAAAAA", 7)
+	if d, _ := tracker.get("AAAAAA"); d.State != deliveryPending {
+		t.Errorf("Message is %s before every unit was echoed", d.State)
+	}
+	tracker.confirm("AAAAAA", 2)
+	if d, _ := tracker.get("AAAAAA"); d.State != deliveryDelivered || d.Attempts != 2 {
+		t.Errorf("Message is %s after %d attempts, wanted delivered after 2", d.State, d.Attempts)
+	}
+
+	// Pending isn't a result and every result is only reported once
+	tracker.track(input.IRCMessage{ID: "BBBBBB"}, 1, false)
+	tracker.track(input.IRCMessage{ID: "BBBBBB"}, 1, false)
+	if want := []string{"AAAAAA delivered", "BBBBBB sent"}; !reflect.DeepEqual(recorder.results, want) {
+		t.Errorf("Sinks got the results %q, wanted %q", recorder.results, want)
+	}
+}
+
+func TestEchoedUnit(t *testing.T) {
+	tests := []struct {
+		tags girc.Tags
+		id   string
+		unit int
+		ok   bool
+	}{
+		{girc.Tags{tagID: "AAAAAA", tagPart: "2"}, "AAAAAA", 2, true},
+		{girc.Tags{tagID: "AAAAAA"}, "", 0, false},
+		{girc.Tags{tagPart: "2"}, "", 0, false},
+	}
+	for _, test := range tests {
+		id, unit, ok := echoedUnit(girc.Event{Tags: test.tags})
+		if id != test.id || unit != test.unit || ok != test.ok {
+			t.Errorf("Echo with tags %v is unit %d of %q (%v)", test.tags, unit, id, ok)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+func isUserTarget(target string) bool {
+	return strings.HasPrefix(target, input.UserTargetPrefix)
+}
+
+// directMessages delivers messages to users by query. When requireOnline is set,
+// messages for users which are offline are queued until the user comes online.
+type directMessages struct {
+	requireOnline bool
+	queueSize     int
+
+	mu     sync.Mutex
+	queues map[string][]input.IRCMessage
+}
+
+var direct = &directMessages{queues: map[string][]input.IRCMessage{}}
+
+func (d *directMessages) configure(config *viper.Viper) {
+	config.SetDefault("require_online", false)
+	config.SetDefault("queue_size", 20)
+	d.requireOnline = config.GetBool("require_online")
+	d.queueSize = config.GetInt("queue_size")
+
+	presence.OnReport(func(nick string, online bool) {
+		if online {
+			d.flush(nick)
+		}
+	})
+
+	// Accounts are only known for users we can see, so messages for an account are
+	// delivered once somebody logged in to it joins one of our channels or logs in
+	for _, command := range []string{girc.JOIN, girc.CAP_ACCOUNT} {
+		client.Handlers.Add(command, func(c *girc.Client, e girc.Event) {
+			if e.Source == nil {
+				return
+			}
+			if user := c.LookupUser(e.Source.Name); user != nil && user.Extras.Account != "" && user.Extras.Account != "*" {
+				d.flush(user.Extras.Account)
+			}
+		})
+	}
+}
+
+// resolveUser returns the nickname for a user target. The name is first looked up as
+// services account of the given users, otherwise it is used as the nickname. found
+// reports if the name is the account of one of the users.
+func resolveUser(target string, users []*girc.User) (nick string, found bool) {
+	name := strings.TrimPrefix(target, input.UserTargetPrefix)
+	for _, user := range users {
+		if user.Extras.Account != "" && girc.ToRFC1459(user.Extras.Account) == girc.ToRFC1459(name) {
+			return user.Nick, true
+		}
+	}
+	return name, false
+}
+
+// prepare resolves the nickname of a message for a user. It returns false when the
+// message was queued because the user is offline.
+func (d *directMessages) prepare(elem *input.IRCMessage) bool {
+	nick, found := resolveUser(elem.Channel, client.Users())
+	// Somebody who is logged in to the account is online
+	if !d.requireOnline || found {
+		elem.Channel = nick
+		return true
+	}
+
+	presence.Watch(nick)
+	if presence.IsOnline(nick) {
+		elem.Channel = nick
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := girc.ToRFC1459(nick)
+	d.queues[id] = append(d.queues[id], *elem)
+	if len(d.queues[id]) > d.queueSize {
+		log.WithFields(log.Fields{
+			"MsgID": d.queues[id][0].ID,
+			"nick":  nick,
+		}).Warn("Queue for offline user is full. Dropping oldest message")
+		deliveries.drop(d.queues[id][0])
+		d.queues[id] = d.queues[id][1:]
+	}
+	log.WithFields(log.Fields{
+		"MsgID": elem.ID,
+		"nick":  nick,
+	}).Info("User is offline. Queued message until the user comes online")
+	return false
+}
+
+// flush sends all queued messages of a user back to the message queue
+func (d *directMessages) flush(nick string) {
+	d.mu.Lock()
+	id := girc.ToRFC1459(nick)
+	queued := d.queues[id]
+	delete(d.queues, id)
+	d.mu.Unlock()
+
+	if len(queued) == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"nick":  nick,
+		"count": len(queued),
+	}).Info("User came online. Delivering queued messages")
+	go func() {
+		for _, elem := range queued {
+			inputChannel <- elem
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+)
+
+func TestIsUserTarget(t *testing.T) {
+	for target, want := range map[string]bool{"@alice": true, "#test": false, "alice": false, "": false} {
+		if isUserTarget(target) != want {
+			t.Errorf("isUserTarget(%q) is %v, wanted %v", target, !want, want)
+		}
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	alice := &girc.User{Nick: "alice_away"}
+	alice.Extras.Account = "Alice"
+	users := []*girc.User{alice, {Nick: "bob"}}
+	tests := []struct {
+		target string
+		nick   string
+		found  bool
+	}{
+		// Accounts are compared case-insensitively
+		{"@alice", "alice_away", true},
+		{"@bob", "bob", false},
+		{"@carol", "carol", false},
+	}
+	for _, test := range tests {
+		nick, found := resolveUser(test.target, users)
+		if nick != test.nick || found != test.found {
+			t.Errorf("resolveUser(%q) is %q (%v), wanted %q (%v)", test.target, nick, found, test.nick, test.found)
+		}
+	}
+}
+
+func TestDirectMessageQueue(t *testing.T) {
+	recorder := recordResults(t)
+	client = girc.New(girc.Config{Server: "irc.example.com", Nick: "CptHook", User: "cpthook"})
+	d := &directMessages{requireOnline: true, queueSize: 2, queues: map[string][]input.IRCMessage{}}
+
+	for _, id := range []string{"EEEEEE", "FFFFFF", "GGGGGG"} {
+		elem := input.IRCMessage{ID: id, Channel: "@alice", Messages: []string{"Test"}}
+		if d.prepare(&elem) {
+			t.Fatalf("Message %s for an offline user wasn't queued", id)
+		}
+	}
+	if want := []string{"EEEEEE dropped"}; !reflect.DeepEqual(recorder.results, want) {
+		t.Errorf("Sinks got the results %q, wanted %q", recorder.results, want)
+	}
+
+	previous := inputChannel
+	inputChannel = make(chan input.IRCMessage, 10)
+	t.Cleanup(func() { inputChannel = previous })
+
+	// The account or nickname is compared case-insensitively
+	d.flush("Alice")
+	for _, id := range []string{"FFFFFF", "GGGGGG"} {
+		select {
+		case elem := <-inputChannel:
+			if elem.ID != id || elem.Channel != "@alice" {
+				t.Errorf("Flushed message %s to %s, wanted %s to @alice", elem.ID, elem.Channel, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Queued message %s wasn't flushed", id)
+		}
+	}
+	if len(d.queues) != 0 {
+		t.Errorf("Queues aren't empty after the flush: %v", d.queues)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+)
+
+const (
+	messageTypePrivmsg = "privmsg"
+	messageTypeNotice  = "notice"
+	// messageTypeAction sends the message like "/me", as CTCP ACTION
+	messageTypeAction = "action"
+)
+
+// defaultMessageType is used when neither the module block nor the channel configure a message type
+var defaultMessageType = messageTypePrivmsg
+
+// blockMessageTypes holds the message types configured in the module blocks, by block name
+var blockMessageTypes = map[string]moduleMessageTypes{}
+
+// moduleMessageTypes are the message types of a single module block
+type moduleMessageTypes struct {
+	// Default applies to all messages of the block
+	Default string
+	// Events overrides Default for single event types, e.g. "push" or "firing"
+	Events map[string]string
+}
+
+func validateMessageType(t string) error {
+	switch t {
+	case "", messageTypePrivmsg, messageTypeNotice, messageTypeAction:
+		return nil
+	}
+	return fmt.Errorf("unknown message type %q, must be one of privmsg, notice or action", t)
+}
+
+// messageType returns the type a message is sent with. The most specific setting wins:
+// the event type of the module block, then the channel, then the module block and
+// finally the global setting.
+func messageType(elem input.IRCMessage) string {
+	block, hasBlock := blockMessageTypes[elem.Block]
+	if hasBlock && elem.EventType != "" {
+		if t := block.Events[elem.EventType]; t != "" {
+			return t
+		}
+	}
+	if c, ok := channelConfigs[girc.ToRFC1459(elem.Channel)]; ok && c.MessageType != "" {
+		return c.MessageType
+	}
+	if hasBlock && block.Default != "" {
+		return block.Default
+	}
+	return defaultMessageType
+}
+
+// messageCommand returns the IRC command for a message type. Actions are PRIVMSGs
+// whose lines are wrapped with actionLine.
+func messageCommand(t string) string {
+	if t == messageTypeNotice {
+		return girc.NOTICE
+	}
+	return girc.PRIVMSG
+}
+
+// actionLine wraps a line as CTCP ACTION
+func actionLine(line string) string {
+	return fmt.Sprintf("\x01ACTION %s\x01", line)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lrstanley/girc"
+)
+
+const multilineCap = "draft/multiline"
+
+// multilineLimits stores the limits the server advertised for draft/multiline batches
+var multilineLimits = struct {
+	sync.Mutex
+	maxBytes int
+	maxLines int
+}{}
+
+// handleMultilineCap parses the values of the draft/multiline capability from CAP LS and CAP NEW
+func handleMultilineCap(c *girc.Client, e girc.Event) {
+	if len(e.Params) < 3 || (e.Params[1] != girc.CAP_LS && e.Params[1] != girc.CAP_NEW) {
+		return
+	}
+
+	for _, capability := range strings.Fields(e.Last()) {
+		name, value, _ := strings.Cut(capability, "=")
+		if name != multilineCap {
+			continue
+		}
+
+		multilineLimits.Lock()
+		multilineLimits.maxBytes, multilineLimits.maxLines = 0, 0
+		for _, option := range strings.Split(value, ",") {
+			k, v, _ := strings.Cut(option, "=")
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				continue
+			}
+			switch k {
+			case "max-bytes":
+				multilineLimits.maxBytes = n
+			case "max-lines":
+				multilineLimits.maxLines = n
+			}
+		}
+		log.WithFields(log.Fields{
+			"maxBytes": multilineLimits.maxBytes,
+			"maxLines": multilineLimits.maxLines,
+		}).Debug("Server supports multiline batches")
+		multilineLimits.Unlock()
+	}
+}
+
+// splitBatches splits the lines of a message into chunks which fit into the limits of a single batch
+func splitBatches(lines []string, maxBytes, maxLines int) [][]string {
+	var batches [][]string
+	var current []string
+	size := 0
+
+	for _, line := range lines {
+		// The combined message includes a newline between every line
+		full := (maxLines > 0 && len(current) >= maxLines) || (maxBytes > 0 && size+len(line)+1 > maxBytes)
+		if len(current) > 0 && full {
+			batches = append(batches, current)
+			current, size = nil, 0
+		}
+		current = append(current, line)
+		size += len(line) + 1
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// multilineBatches splits the lines of a message into batches within the limits of the server
+func multilineBatches(lines []string) [][]string {
+	multilineLimits.Lock()
+	defer multilineLimits.Unlock()
+	return splitBatches(lines, multilineLimits.maxBytes, multilineLimits.maxLines)
+}
+
+// sendMultiline sends the lines as a single draft/multiline batch so they show up as one
+// message in clients and don't interleave with other messages
+func sendMultiline(command string, target string, ref string, lines []string, tags girc.Tags) {
+	id := strings.ToLower(ref)
+	// Client-only tags are only allowed on the opening BATCH command
+	client.Send(&girc.Event{Tags: tags, Command: "BATCH", Params: []string{"+" + id, multilineCap, target}})
+	for _, line := range lines {
+		client.Send(&girc.Event{
+			Tags:    girc.Tags{"batch": id},
+			Command: command,
+			Params:  []string{target, line},
+		})
+	}
+	client.Send(&girc.Event{Command: "BATCH", Params: []string{"-" + id}})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMultilineBatches(t *testing.T) {
+	lines := []string{"first", "second", "third", "fourth", "fifth"}
+	tests := []struct {
+		maxBytes int
+		maxLines int
+		want     [][]string
+	}{
+		{0, 0, [][]string{lines}},
+		{0, 2, [][]string{{"first", "second"}, {"third", "fourth"}, {"fifth"}}},
+		// Every line counts with a newline
+		{13, 0, [][]string{{"first", "second"}, {"third", "fourth"}, {"fifth"}}},
+		{12, 0, [][]string{{"first"}, {"second"}, {"third"}, {"fourth"}, {"fifth"}}},
+	}
+	defer func() { multilineLimits.maxBytes, multilineLimits.maxLines = 0, 0 }()
+	for _, test := range tests {
+		multilineLimits.maxBytes, multilineLimits.maxLines = test.maxBytes, test.maxLines
+		// The delivery expects one echo for every batch
+		if got := multilineBatches(lines); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split with max-bytes=%d max-lines=%d into %q, wanted %q", test.maxBytes, test.maxLines, got, test.want)
+		}
+	}
+}
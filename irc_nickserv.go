@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+// nickServ identifies the bot to NickServ and tries to get the configured nickname back
+// when we had to connect with a fallback nickname
+type nickServ struct {
+	service  string
+	account  string
+	password string
+	identify bool
+	recover  string
+	nickname string
+	// interval is the time between attempts to reclaim the nickname while it is in use
+	interval time.Duration
+}
+
+func newNickServ(config *viper.Viper, nickname string, saslConfigured bool) (*nickServ, error) {
+	config.SetDefault("service", "NickServ")
+	config.SetDefault("account", nickname)
+	config.SetDefault("identify", !saslConfigured)
+	config.SetDefault("reclaim_interval", 5*time.Minute)
+
+	ns := &nickServ{
+		service:  config.GetString("service"),
+		account:  config.GetString("account"),
+		password: config.GetString("password"),
+		identify: config.GetBool("identify"),
+		recover:  strings.ToUpper(config.GetString("recover")),
+		nickname: nickname,
+		interval: config.GetDuration("reclaim_interval"),
+	}
+
+	switch ns.recover {
+	case "", "GHOST", "REGAIN", "RECOVER":
+	default:
+		return nil, fmt.Errorf("unsupported nickserv recover method %q", ns.recover)
+	}
+
+	if ns.password == "" && (ns.identify || ns.recover != "") {
+		return nil, fmt.Errorf("nickserv password is required to identify or recover the nickname")
+	}
+	if ns.interval <= 0 {
+		return nil, fmt.Errorf("reclaim_interval must be positive")
+	}
+
+	return ns, nil
+}
+
+func (ns *nickServ) send(command string, args ...string) {
+	client.Send(&girc.Event{
+		Command:   girc.PRIVMSG,
+		Params:    []string{ns.service, strings.Join(append([]string{command}, args...), " ")},
+		Sensitive: true,
+	})
+}
+
+// reclaimCommand decides how to get the configured nickname back while we use current:
+// NICK when the nickname is free, the recover method of NickServ when it is in use and
+// nothing when we already have it or no recover method is configured
+func (ns *nickServ) reclaimCommand(current string, nickIsFree bool) string {
+	if girc.ToRFC1459(current) == girc.ToRFC1459(ns.nickname) {
+		return ""
+	}
+	if nickIsFree {
+		return girc.NICK
+	}
+	return ns.recover
+}
+
+// reclaim tries to get the configured nickname back, either by asking NickServ to
+// disconnect the current owner or simply by changing our nick when the nick is free
+func (ns *nickServ) reclaim(nickIsFree bool) {
+	switch ns.reclaimCommand(client.GetNick(), nickIsFree) {
+	case girc.NICK:
+		log.WithFields(log.Fields{
+			"nick": ns.nickname,
+		}).Info("Configured nickname is available again. Reclaiming it")
+		client.Cmd.Nick(ns.nickname)
+	case "GHOST":
+		log.Info("Asking NickServ to disconnect the user with our configured nickname")
+		ns.send("GHOST", ns.nickname, ns.password)
+	case "REGAIN", "RECOVER":
+		log.Infof("Asking NickServ to %s our configured nickname", strings.ToLower(ns.recover))
+		ns.send(ns.recover, ns.nickname, ns.password)
+	}
+}
+
+func (ns *nickServ) register(c *girc.Client) {
+	c.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		if ns.identify {
+			log.WithFields(log.Fields{
+				"account": ns.account,
+			}).Info("Identifying to NickServ")
+			ns.send("IDENTIFY", ns.account, ns.password)
+		}
+		ns.reclaim(false)
+	})
+
+	presence.Watch(ns.nickname)
+	presence.OnReport(func(nick string, online bool) {
+		if girc.ToRFC1459(nick) == girc.ToRFC1459(ns.nickname) && !online {
+			ns.reclaim(true)
+		}
+	})
+
+	// The owner of the nickname may stay online, so we keep asking NickServ
+	go func() {
+		for range time.Tick(ns.interval) {
+			if client.IsConnected() {
+				ns.reclaim(false)
+			}
+		}
+	}()
+
+	// NickServ only sends a notice after a successful GHOST, so we retry to
+	// change our nick shortly after the request
+	if ns.recover == "GHOST" {
+		c.Handlers.Add(girc.NOTICE, func(c *girc.Client, e girc.Event) {
+			if e.Source == nil || e.Source.ID() != girc.ToRFC1459(ns.service) {
+				return
+			}
+			if strings.Contains(strings.ToLower(e.Last()), "ghost") {
+				time.AfterFunc(2*time.Second, func() { ns.reclaim(true) })
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+func TestNickServConfig(t *testing.T) {
+	tests := []struct {
+		settings map[string]interface{}
+		sasl     bool
+		valid    bool
+	}{
+		{map[string]interface{}{"password": "secret", "recover": "regain"}, false, true},
+		// Without SASL we identify to NickServ, which needs the password
+		{map[string]interface{}{}, false, false},
+		{map[string]interface{}{}, true, true},
+		{map[string]interface{}{"recover": "GHOST"}, true, false},
+		{map[string]interface{}{"password": "secret", "recover": "KILL"}, false, false},
+		{map[string]interface{}{"password": "secret", "reclaim_interval": "0s"}, false, false},
+	}
+	for _, test := range tests {
+		config := viper.New()
+		for k, v := range test.settings {
+			config.Set(k, v)
+		}
+		_, err := newNickServ(config, "CptHook", test.sasl)
+		if test.valid && err != nil {
+			t.Errorf("NickServ with %v failed: %s", test.settings, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("NickServ with %v didn't fail", test.settings)
+		}
+	}
+}
+
+func TestNickServReclaimCommand(t *testing.T) {
+	tests := []struct {
+		recover    string
+		current    string
+		nickIsFree bool
+		want       string
+	}{
+		{"REGAIN", "CptHook", false, ""},
+		// Nicknames are case-insensitive
+		{"REGAIN", "cpthook", true, ""},
+		{"REGAIN", "CptHook_", true, girc.NICK},
+		{"REGAIN", "CptHook_", false, "REGAIN"},
+		{"GHOST", "CptHook_", false, "GHOST"},
+		// Without recover method we wait until the nickname is free
+		{"", "CptHook_", false, ""},
+		{"", "CptHook_", true, girc.NICK},
+	}
+	for _, test := range tests {
+		ns := &nickServ{nickname: "CptHook", recover: test.recover}
+		if got := ns.reclaimCommand(test.current, test.nickIsFree); got != test.want {
+			t.Errorf("Reclaim as %s with recover %q and free nickname %v is %q, wanted %q", test.current, test.recover, test.nickIsFree, got, test.want)
+		}
+	}
+}
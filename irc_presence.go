@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lrstanley/girc"
+)
+
+// presenceTracker keeps track if a set of nicknames is currently online.
+// It uses MONITOR when the server supports it and falls back to polling with ISON.
+type presenceTracker struct {
+	mu       sync.Mutex
+	watched  map[string]string // rfc1459 nick -> nick as configured
+	online   map[string]bool
+	handlers []func(nick string, online bool)
+}
+
+var presence = &presenceTracker{
+	watched: map[string]string{},
+	online:  map[string]bool{},
+}
+
+// Watch adds a nickname to the list of watched nicknames
+func (p *presenceTracker) Watch(nick string) {
+	p.mu.Lock()
+	id := girc.ToRFC1459(nick)
+	_, known := p.watched[id]
+	p.watched[id] = nick
+	p.mu.Unlock()
+
+	if !known && client != nil && client.IsConnected() && monitorSupported() {
+		client.Cmd.Monitor('+', nick)
+	}
+}
+
+// OnReport registers a function which is called every time the server reports the state of a watched nickname
+func (p *presenceTracker) OnReport(f func(nick string, online bool)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, f)
+}
+
+// IsOnline reports if a watched nickname was seen online the last time we checked
+func (p *presenceTracker) IsOnline(nick string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.online[girc.ToRFC1459(nick)]
+}
+
+func (p *presenceTracker) nicks() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var nicks []string
+	for _, nick := range p.watched {
+		nicks = append(nicks, nick)
+	}
+	return nicks
+}
+
+func (p *presenceTracker) update(nick string, online bool) {
+	id := girc.ToRFC1459(nick)
+	p.mu.Lock()
+	configured, ok := p.watched[id]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	changed := p.online[id] != online
+	p.online[id] = online
+	handlers := append([]func(string, bool){}, p.handlers...)
+	p.mu.Unlock()
+
+	if changed {
+		log.WithFields(log.Fields{
+			"nick":   configured,
+			"online": online,
+		}).Debug("Presence of watched nickname changed")
+	}
+	// Handlers are called for every report, not only for changes, so they can
+	// retry actions which failed the last time
+	for _, f := range handlers {
+		f(configured, online)
+	}
+}
+
+// handleMonitor parses RPL_MONONLINE and RPL_MONOFFLINE, which list the targets separated
+// by commas. Online targets are full hostmasks.
+func (p *presenceTracker) handleMonitor(e girc.Event, online bool) {
+	for _, target := range strings.Split(e.Last(), ",") {
+		p.update(strings.SplitN(target, "!", 2)[0], online)
+	}
+}
+
+// handleISON parses RPL_ISON. Every watched nickname which is missing is offline.
+func (p *presenceTracker) handleISON(e girc.Event) {
+	online := map[string]bool{}
+	for _, nick := range strings.Fields(e.Last()) {
+		online[girc.ToRFC1459(nick)] = true
+	}
+	for _, nick := range p.nicks() {
+		p.update(nick, online[girc.ToRFC1459(nick)])
+	}
+}
+
+func monitorSupported() bool {
+	_, ok := client.GetServerOption("MONITOR")
+	return ok
+}
+
+// register adds the IRC handlers which feed the tracker and starts to poll with ISON
+// if the server doesn't support MONITOR
+func (p *presenceTracker) register(c *girc.Client, interval time.Duration) {
+	c.Handlers.Add(girc.RPL_MONONLINE, func(c *girc.Client, e girc.Event) {
+		p.handleMonitor(e, true)
+	})
+	c.Handlers.Add(girc.RPL_MONOFFLINE, func(c *girc.Client, e girc.Event) {
+		p.handleMonitor(e, false)
+	})
+	c.Handlers.Add(girc.RPL_ISON, func(c *girc.Client, e girc.Event) {
+		p.handleISON(e)
+	})
+
+	c.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		p.mu.Lock()
+		p.online = map[string]bool{}
+		p.mu.Unlock()
+
+		nicks := p.nicks()
+		if len(nicks) > 0 && monitorSupported() {
+			log.Info("Using MONITOR to track watched nicknames")
+			c.Cmd.Monitor('+', nicks...)
+		}
+	})
+
+	go func() {
+		for range time.Tick(interval) {
+			if !client.IsConnected() || monitorSupported() {
+				continue
+			}
+			if nicks := p.nicks(); len(nicks) > 0 {
+				client.Cmd.SendRaw("ISON " + strings.Join(nicks, " "))
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestPresenceReplies(t *testing.T) {
+	p := &presenceTracker{watched: map[string]string{}, online: map[string]bool{}}
+	p.Watch("Alice")
+	p.Watch("bob")
+
+	var reports []string
+	p.OnReport(func(nick string, online bool) {
+		if online {
+			reports = append(reports, nick+" online")
+		} else {
+			reports = append(reports, nick+" offline")
+		}
+	})
+
+	tests := []struct {
+		line    string
+		want    []string
+		online  []string
+		offline []string
+	}{
+		// Unwatched nicknames are ignored, watched ones are reported as configured
+		{":irc.example.com 730 CptHook :alice!a@example.com,carol!c@example.com", []string{"Alice online"}, []string{"alice"}, []string{"bob"}},
+		{":irc.example.com 731 CptHook :ALICE", []string{"Alice offline"}, nil, []string{"Alice", "bob"}},
+		{":irc.example.com 303 CptHook :Bob carol", []string{"Alice offline", "bob online"}, []string{"bob"}, []string{"Alice"}},
+		{":irc.example.com 303 CptHook :", []string{"Alice offline", "bob offline"}, nil, []string{"Alice", "bob"}},
+	}
+	for _, test := range tests {
+		reports = nil
+		e := girc.ParseEvent(test.line)
+		switch e.Command {
+		case girc.RPL_MONONLINE:
+			p.handleMonitor(*e, true)
+		case girc.RPL_MONOFFLINE:
+			p.handleMonitor(*e, false)
+		case girc.RPL_ISON:
+			p.handleISON(*e)
+		}
+
+		// ISON reports the nicknames in random order
+		if len(reports) == 2 && reports[0] > reports[1] {
+			reports[0], reports[1] = reports[1], reports[0]
+		}
+		if !reflect.DeepEqual(reports, test.want) {
+			t.Errorf("%q reported %q, wanted %q", test.line, reports, test.want)
+		}
+		for _, nick := range test.online {
+			if !p.IsOnline(nick) {
+				t.Errorf("%s is offline after %q", nick, test.line)
+			}
+		}
+		for _, nick := range test.offline {
+			if p.IsOnline(nick) {
+				t.Errorf("%s is online after %q", nick, test.line)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/proxy"
+)
+
+// dialer implements girc.Dialer. It optionally binds to a local address, forces
+// the IP version and connects through a SOCKS5 or HTTP CONNECT proxy.
+type dialer struct {
+	network string
+	direct  *net.Dialer
+	proxy   proxy.Dialer
+}
+
+func newDialer(config *viper.Viper) (*dialer, error) {
+	d := &dialer{
+		network: "tcp",
+		direct:  &net.Dialer{Timeout: 5 * time.Second},
+	}
+
+	switch config.GetInt("ip_version") {
+	case 0:
+	case 4:
+		d.network = "tcp4"
+	case 6:
+		d.network = "tcp6"
+	default:
+		return nil, fmt.Errorf("unsupported ip_version %d", config.GetInt("ip_version"))
+	}
+
+	if bind := config.GetString("bind"); bind != "" {
+		local, err := net.ResolveTCPAddr(d.network, net.JoinHostPort(bind, "0"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bind address: %w", err)
+		}
+		d.direct.LocalAddr = local
+	}
+
+	if !config.IsSet("proxy") {
+		return d, nil
+	}
+
+	address := config.GetString("proxy.address")
+	username := config.GetString("proxy.username")
+	password := config.GetString("proxy.password")
+
+	switch config.GetString("proxy.type") {
+	case "socks5":
+		var auth *proxy.Auth
+		if username != "" {
+			auth = &proxy.Auth{User: username, Password: password}
+		}
+		p, err := proxy.SOCKS5(d.network, address, auth, d.direct)
+		if err != nil {
+			return nil, err
+		}
+		d.proxy = p
+	case "http":
+		d.proxy = &httpConnectDialer{
+			address:  address,
+			username: username,
+			password: password,
+			forward:  d.direct,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy type %q", config.GetString("proxy.type"))
+	}
+
+	return d, nil
+}
+
+// Dial connects to the IRC server. TLS is done by girc on top of this connection,
+// so it works the same way with and without a proxy.
+func (d *dialer) Dial(network, address string) (net.Conn, error) {
+	if d.proxy != nil {
+		return d.proxy.Dial(d.network, address)
+	}
+	return d.direct.Dial(d.network, address)
+}
+
+// httpConnectDialer opens a tunnel to the IRC server with a HTTP CONNECT request
+type httpConnectDialer struct {
+	address  string
+	username string
+	password string
+	forward  *net.Dialer
+}
+
+func (h *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := h.forward.Dial(network, h.address)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if h.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(h.username + ":" + h.password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+
+	conn.SetDeadline(time.Now().Add(h.forward.Timeout))
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused the connection: %s", resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	// The server may already have sent data which is now in our buffer
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// fakeHTTPProxy accepts a single CONNECT request and answers it with response
+func fakeHTTPProxy(t *testing.T, listener net.Listener, response string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		t.Errorf("Proxy received invalid request: %s", err)
+		return
+	}
+	if req.Method != http.MethodConnect || req.Host != "irc.example.com:6697" {
+		t.Errorf("Proxy received %s %s, wanted CONNECT irc.example.com:6697", req.Method, req.Host)
+	}
+	// "cpthook:secret"
+	if auth := req.Header.Get("Proxy-Authorization"); auth != "Basic Y3B0aG9vazpzZWNyZXQ=" {
+		t.Errorf("Proxy received wrong credentials %q", auth)
+	}
+	conn.Write([]byte(response))
+}
+
+func proxyConfig(address string) *viper.Viper {
+	config := viper.New()
+	config.Set("proxy.type", "http")
+	config.Set("proxy.address", address)
+	config.Set("proxy.username", "cpthook")
+	config.Set("proxy.password", "secret")
+	return config
+}
+
+func TestHTTPConnectDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// The IRC server already greets us in the same packet as the response of the proxy
+	go fakeHTTPProxy(t, listener, "HTTP/1.1 200 Connection established\r\n\r\n:irc.example.com NOTICE * :Hello\r\n")
+
+	d, err := newDialer(proxyConfig(listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", "irc.example.com:6697")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != ":irc.example.com NOTICE * :Hello\r\n" {
+		t.Errorf("Read %q from the tunnel (%v), wanted the greeting of the IRC server", line, err)
+	}
+}
+
+func TestHTTPConnectDialerRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go fakeHTTPProxy(t, listener, "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")
+
+	d, err := newDialer(proxyConfig(listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Dial("tcp", "irc.example.com:6697"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Dialing through a refusing proxy returned %v", err)
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	tests := []struct {
+		settings map[string]interface{}
+		network  string
+		valid    bool
+	}{
+		{map[string]interface{}{}, "tcp", true},
+		{map[string]interface{}{"ip_version": 4, "bind": "127.0.0.1"}, "tcp4", true},
+		{map[string]interface{}{"ip_version": 6, "bind": "::1"}, "tcp6", true},
+		{map[string]interface{}{"ip_version": 5}, "", false},
+		// The bind address has to match the IP version
+		{map[string]interface{}{"ip_version": 4, "bind": "::1"}, "", false},
+		{map[string]interface{}{"proxy.type": "ftp", "proxy.address": "127.0.0.1:21"}, "", false},
+	}
+	for _, test := range tests {
+		config := viper.New()
+		for k, v := range test.settings {
+			config.Set(k, v)
+		}
+		d, err := newDialer(config)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("Dialer with %v didn't fail", test.settings)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Dialer with %v failed: %s", test.settings, err)
+			continue
+		}
+		if d.network != test.network {
+			t.Errorf("Dialer with %v uses network %s, wanted %s", test.settings, d.network, test.network)
+		}
+	}
+}
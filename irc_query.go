@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+const sourceURL = "https://github.com/fleaz/CptHook"
+
+const defaultQueryReply = "Hi. I'm a CptHook bot. Visit {{ .Source }} to learn more." +
+	"{{ if eq .Version \"dev\" }} I was compiled by hand at {{ .Date }}" +
+	"{{ else }} I am running v{{ .Version }} (Commit: {{ .Commit }}, Builddate: {{ .Date }}){{ end }}"
+
+// queryReplyContext is available in the query_reply template
+type queryReplyContext struct {
+	Nick    string
+	Sender  string
+	Version string
+	Commit  string
+	Date    string
+	Source  string
+}
+
+// queryReply answers private messages with a configurable text
+type queryReply struct {
+	template *template.Template
+	limiter  *rateLimiter
+}
+
+// newQueryReply creates the answers to private messages. The limiter is shared with the
+// CTCP answers.
+func newQueryReply(config *viper.Viper, limiter *rateLimiter) (*queryReply, error) {
+	config.SetDefault("template", defaultQueryReply)
+
+	t, err := template.New("query reply").Parse(config.GetString("template"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryReply{
+		template: t,
+		limiter:  limiter,
+	}, nil
+}
+
+func (q *queryReply) handle(c *girc.Client, e girc.Event) {
+	if !e.IsFromUser() {
+		return
+	}
+	if ok, _ := e.IsCTCP(); ok {
+		// CTCP requests are answered by the CTCP handlers
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"Event": e.String(),
+	}).Debug("Received a PRIMSG")
+
+	if !q.limiter.Allow(e.Source.ID()) {
+		log.WithFields(log.Fields{
+			"sender": e.Source.Name,
+		}).Debug("Not answering private message because of the rate limit")
+		return
+	}
+
+	var buf bytes.Buffer
+	err := q.template.Execute(&buf, queryReplyContext{
+		Nick:    c.GetNick(),
+		Sender:  e.Source.Name,
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+		Source:  sourceURL,
+	})
+	if err != nil {
+		log.Errorf("Failed to render query reply: %s", err)
+		return
+	}
+	if buf.Len() > 0 {
+		c.Cmd.ReplyTo(e, buf.String())
+	}
+}
+
+// versionString is sent as answer to CTCP VERSION
+func versionString() string {
+	if version == "dev" {
+		return fmt.Sprintf("CptHook (compiled by hand at %v)", date)
+	}
+	return fmt.Sprintf("CptHook v%v (Commit: %v, Builddate: %v)", version, commit, date)
+}
+
+// registerCTCP sets up the answers to CTCP VERSION, PING, TIME and SOURCE. Each user
+// gets at most one answer per command within the interval of the limiter.
+func registerCTCP(c *girc.Client, limiter *rateLimiter) {
+	answer := func(command string, text func(ctcp girc.CTCPEvent) string) {
+		c.CTCP.Set(command, func(c *girc.Client, ctcp girc.CTCPEvent) {
+			if ctcp.Reply || ctcp.Source == nil {
+				return
+			}
+			if !limiter.Allow(command + " " + ctcp.Source.ID()) {
+				log.WithFields(log.Fields{
+					"sender":  ctcp.Source.Name,
+					"command": command,
+				}).Debug("Not answering CTCP request because of the rate limit")
+				return
+			}
+			c.Cmd.SendCTCPReply(ctcp.Source.ID(), command, text(ctcp))
+		})
+	}
+	answer(girc.CTCP_VERSION, func(girc.CTCPEvent) string { return versionString() })
+	answer(girc.CTCP_SOURCE, func(girc.CTCPEvent) string { return sourceURL })
+	answer(girc.CTCP_PING, func(ctcp girc.CTCPEvent) string { return ctcp.Text })
+	answer(girc.CTCP_TIME, func(girc.CTCPEvent) string { return time.Now().Format(time.RFC1123Z) })
+}
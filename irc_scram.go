@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// saslSCRAM implements the SASL SCRAM-SHA-256 and SCRAM-SHA-512 mechanisms (RFC 5802, RFC 7677).
+// Only a proof derived from the password is sent to the server, never the password itself.
+// girc calls Encode once for every AUTHENTICATE message of the server.
+type saslSCRAM struct {
+	User string
+	Pass string
+
+	method string
+	hash   func() hash.Hash
+
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+}
+
+func newSASLSCRAM(method, user, pass string) (*saslSCRAM, error) {
+	s := &saslSCRAM{User: user, Pass: pass, method: method}
+	switch method {
+	case "SCRAM-SHA-256":
+		s.hash = sha256.New
+	case "SCRAM-SHA-512":
+		s.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported SCRAM mechanism %q", method)
+	}
+	return s, nil
+}
+
+// Method identifies the mechanism in the AUTHENTICATE command
+func (s *saslSCRAM) Method() string {
+	return s.method
+}
+
+// Encode answers the current message of the server. An empty string aborts the authentication.
+func (s *saslSCRAM) Encode(params []string) string {
+	if len(params) != 1 {
+		return ""
+	}
+
+	// Every authentication starts with an empty challenge, e.g. again after a reconnect
+	if params[0] == "+" {
+		return s.clientFirst()
+	}
+
+	message, err := base64.StdEncoding.DecodeString(params[0])
+	if err != nil {
+		log.Errorf("SASL %s: Invalid message from server: %s", s.method, err)
+		return ""
+	}
+
+	switch s.step {
+	case 1:
+		final, err := s.clientFinal(string(message))
+		if err != nil {
+			log.Errorf("SASL %s: %s", s.method, err)
+			return ""
+		}
+		return final
+	case 2:
+		if err := s.verifyServer(string(message)); err != nil {
+			log.Errorf("SASL %s: %s", s.method, err)
+			return ""
+		}
+		return "+"
+	}
+	return ""
+}
+
+func (s *saslSCRAM) clientFirst() string {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Errorf("SASL %s: Failed to generate nonce: %s", s.method, err)
+		return ""
+	}
+	return s.clientFirstWithNonce(base64.RawStdEncoding.EncodeToString(nonce))
+}
+
+// clientFirstWithNonce starts the authentication with the given client nonce
+func (s *saslSCRAM) clientFirstWithNonce(nonce string) string {
+	s.clientNonce = nonce
+	s.clientFirstBare = fmt.Sprintf("n=%s,r=%s", scramEscape(s.User), s.clientNonce)
+	s.step = 1
+	// No channel binding and no authorization identity
+	return base64.StdEncoding.EncodeToString([]byte("n,," + s.clientFirstBare))
+}
+
+func (s *saslSCRAM) clientFinal(serverFirst string) (string, error) {
+	attributes := scramAttributes(serverFirst)
+
+	nonce := attributes["r"]
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return "", fmt.Errorf("server nonce doesn't extend our nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	if err != nil || len(salt) == 0 {
+		return "", fmt.Errorf("invalid salt from server")
+	}
+	iterations, err := strconv.Atoi(attributes["i"])
+	if err != nil || iterations < 1 {
+		return "", fmt.Errorf("invalid iteration count from server")
+	}
+
+	// "biws" is the base64 encoded GS2 header "n,,"
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := s.clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	saltedPassword := pbkdf2.Key([]byte(s.Pass), salt, iterations, s.hash().Size(), s.hash)
+	clientKey := s.hmac(saltedPassword, "Client Key")
+	storedKey := s.hash()
+	storedKey.Write(clientKey)
+	clientSignature := s.hmac(storedKey.Sum(nil), authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverKey := s.hmac(saltedPassword, "Server Key")
+	s.serverSignature = s.hmac(serverKey, authMessage)
+	s.step = 2
+
+	final := withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+	return base64.StdEncoding.EncodeToString([]byte(final)), nil
+}
+
+// verifyServer checks that the server knows the password as well
+func (s *saslSCRAM) verifyServer(serverFinal string) error {
+	attributes := scramAttributes(serverFinal)
+	if e, ok := attributes["e"]; ok {
+		return fmt.Errorf("server rejected the authentication: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attributes["v"])
+	if err != nil || subtle.ConstantTimeCompare(signature, s.serverSignature) != 1 {
+		return fmt.Errorf("invalid server signature")
+	}
+	s.step = 3
+	return nil
+}
+
+func (s *saslSCRAM) hmac(key []byte, message string) []byte {
+	mac := hmac.New(s.hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// scramAttributes parses a message like "r=abc,s=def,i=4096"
+func scramAttributes(message string) map[string]string {
+	attributes := map[string]string{}
+	for _, part := range strings.Split(message, ",") {
+		if len(part) >= 2 && part[1] == '=' {
+			attributes[part[:1]] = part[2:]
+		}
+	}
+	return attributes
+}
+
+// scramEscape escapes the characters which have a meaning in SCRAM messages
+func scramEscape(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
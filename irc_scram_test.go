@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// TestSCRAMSHA256 runs the example authentication of RFC 7677, section 3
+func TestSCRAMSHA256(t *testing.T) {
+	s, err := newSASLSCRAM("SCRAM-SHA-256", "user", "pencil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(message string) string {
+		return base64.StdEncoding.EncodeToString([]byte(message))
+	}
+	decode := func(message string) string {
+		decoded, err := base64.StdEncoding.DecodeString(message)
+		if err != nil {
+			t.Fatalf("Client sent invalid base64 %q", message)
+		}
+		return string(decoded)
+	}
+
+	if first := decode(s.clientFirstWithNonce("rOprNGfwEbeRWgbNEkqO")); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("Client sent first message %q", first)
+	}
+
+	final := s.Encode([]string{encode("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")})
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; decode(final) != want {
+		t.Errorf("Client sent final message %q, wanted %q", decode(final), want)
+	}
+
+	// A server which doesn't know the password can't sign the authentication
+	forged := *s
+	if reply := forged.Encode([]string{encode("v=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")}); reply != "" {
+		t.Errorf("Client accepted an invalid server signature with %q", reply)
+	}
+
+	if reply := s.Encode([]string{encode("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")}); reply != "+" {
+		t.Errorf("Client rejected the server signature with %q", reply)
+	}
+}
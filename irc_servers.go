@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+// ircServer is one entry of the irc.servers list
+type ircServer struct {
+	Host string `mapstructure:"host" json:"host"`
+	Port int    `mapstructure:"port" json:"port"`
+	SSL  *bool  `mapstructure:"ssl" json:"ssl,omitempty"`
+}
+
+func (s ircServer) String() string {
+	return fmt.Sprintf("%s:%d", s.Host, s.Port)
+}
+
+// loadServers returns the configured list of servers. If no list is configured,
+// the single server from the host and port options is used.
+func loadServers(config *viper.Viper) []ircServer {
+	var servers []ircServer
+	if err := config.UnmarshalKey("servers", &servers); err != nil {
+		log.Fatalf("Failed to unmarshal server list: %s", err)
+	}
+	if len(servers) == 0 {
+		servers = append(servers, ircServer{
+			Host: config.GetString("host"),
+			Port: config.GetInt("port"),
+		})
+	}
+	for i := range servers {
+		if servers[i].Port == 0 {
+			servers[i].Port = 6667
+		}
+	}
+	return servers
+}
+
+// apply configures the client to connect to this server on the next connect. Servers
+// without their own ssl setting use globalSSL, the setting from irc.ssl.enabled.
+func (s ircServer) apply(c *girc.Config, globalSSL bool) {
+	c.Server = s.Host
+	c.Port = s.Port
+	c.SSL = globalSSL
+	if s.SSL != nil {
+		c.SSL = *s.SSL
+	}
+	if c.SSL {
+		if c.TLSConfig == nil {
+			c.TLSConfig = &tls.Config{}
+		} else {
+			c.TLSConfig = c.TLSConfig.Clone()
+		}
+		c.TLSConfig.ServerName = s.Host
+	}
+}
+
+// backoff calculates exponentially growing delays with jitter between reconnects
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff(config *viper.Viper) *backoff {
+	config.SetDefault("reconnect.initial_delay", 5*time.Second)
+	config.SetDefault("reconnect.max_delay", 5*time.Minute)
+	return &backoff{
+		initial: config.GetDuration("reconnect.initial_delay"),
+		max:     config.GetDuration("reconnect.max_delay"),
+	}
+}
+
+// next returns the delay before the next attempt. The delay is chosen randomly
+// between half and the full exponential delay, which is capped at the maximum.
+func (b *backoff) next() time.Duration {
+	delay := b.max
+	if b.attempt < 32 && b.initial<<b.attempt < b.max {
+		delay = b.initial << b.attempt
+	}
+	b.attempt++
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// connectionStatus describes the current state of the IRC connection
+type connectionStatus struct {
+	mu          sync.Mutex
+	Connected   bool       `json:"connected"`
+	Server      string     `json:"server"`
+	Attempt     int        `json:"attempt"`
+	Since       time.Time  `json:"since"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+var connStatus = &connectionStatus{}
+
+func (s *connectionStatus) connecting(server ircServer, attempt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Connected = false
+	s.Server = server.String()
+	s.Attempt = attempt
+	s.Since = time.Now()
+	s.NextAttempt = nil
+}
+
+func (s *connectionStatus) connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Connected = true
+	s.Since = time.Now()
+	s.LastError = ""
+}
+
+// disconnected records the reason of the disconnect and returns if we were connected before
+func (s *connectionStatus) disconnected(err error, next time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wasConnected := s.Connected
+	s.Connected = false
+	s.Since = time.Now()
+	if err != nil {
+		s.LastError = err.Error()
+	}
+	s.NextAttempt = &next
+	return wasConnected
+}
+
+func (s *connectionStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// connectLoop connects to the configured servers in rotation and reconnects with
+// an exponential backoff after the connection was lost
+func connectLoop(config *viper.Viper) {
+	servers := loadServers(config)
+	delays := newBackoff(config)
+	d, err := newDialer(config)
+	if err != nil {
+		log.Fatalf("Invalid connection configuration: %s", err)
+	}
+	if config.IsSet("proxy") {
+		log.WithFields(log.Fields{
+			"type":    config.GetString("proxy.type"),
+			"address": config.GetString("proxy.address"),
+		}).Info("Connecting to IRC through a proxy")
+	}
+
+	client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		connStatus.connected()
+		delays.reset()
+	})
+
+	// The config of the client is changed for every server, so keep the global setting
+	globalSSL := client.Config.SSL
+
+	index := 0
+	for attempt := 1; ; attempt++ {
+		server := servers[index%len(servers)]
+		server.apply(&client.Config, globalSSL)
+		connStatus.connecting(server, attempt)
+		log.WithFields(log.Fields{
+			"server":  server.String(),
+			"attempt": attempt,
+		}).Info("Connecting to IRC server")
+
+		// client.Connect() blocks while we are connected.
+		// If the the connection is dropped/broken (recognized if we don't get a PONG 30 seconds
+		// after we sent a PING) an error is returned.
+		err := client.DialerConnect(d)
+		// If we manually Close() the connection, the Connect() function will exit without an error
+		if err != nil {
+			log.WithFields(log.Fields{
+				"server": server.String(),
+			}).Warnf("Connection terminated. Reason: %s", err)
+		}
+
+		delay := delays.next()
+		if wasConnected := connStatus.disconnected(err, time.Now().Add(delay)); wasConnected {
+			// Try the same server again first, the connection worked before
+			attempt = 0
+		} else {
+			index++
+		}
+
+		log.WithFields(log.Fields{
+			"server": servers[index%len(servers)].String(),
+		}).Warnf("Reconnecting in %s...", delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+}
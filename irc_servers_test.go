@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/spf13/viper"
+)
+
+func TestLoadServers(t *testing.T) {
+	config := viper.New()
+	config.Set("host", "irc.example.com")
+	config.Set("port", 6697)
+	if servers := loadServers(config); !reflect.DeepEqual(servers, []ircServer{{Host: "irc.example.com", Port: 6697}}) {
+		t.Errorf("Servers without list are %v, wanted irc.example.com:6697", servers)
+	}
+
+	config.Set("servers", []map[string]interface{}{
+		{"host": "a.example.com"},
+		{"host": "b.example.com", "port": 6697, "ssl": true},
+	})
+	enabled := true
+	want := []ircServer{{Host: "a.example.com", Port: 6667}, {Host: "b.example.com", Port: 6697, SSL: &enabled}}
+	if servers := loadServers(config); !reflect.DeepEqual(servers, want) {
+		t.Errorf("Servers are %v, wanted %v", servers, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{initial: time.Second, max: 10 * time.Second}
+	for _, max := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		max *= time.Second
+		if delay := b.next(); delay < max/2 || delay > max {
+			t.Errorf("Delay is %s, wanted between %s and %s", delay, max/2, max)
+		}
+	}
+	b.reset()
+	if delay := b.next(); delay > time.Second {
+		t.Errorf("Delay after reset is %s, wanted at most 1s", delay)
+	}
+}
+
+func TestServerRotationSSL(t *testing.T) {
+	disabled, enabled := false, true
+	servers := []ircServer{
+		{Host: "a.example.com", Port: 6667, SSL: &disabled},
+		{Host: "b.example.com", Port: 6697},
+		{Host: "c.example.com", Port: 6697, SSL: &enabled},
+	}
+
+	for _, globalSSL := range []bool{true, false} {
+		config := girc.Config{SSL: globalSSL}
+		// Rotate twice, so every server follows every other server
+		for i := 0; i < 2*len(servers); i++ {
+			server := servers[i%len(servers)]
+			server.apply(&config, globalSSL)
+
+			want := globalSSL
+			if server.SSL != nil {
+				want = *server.SSL
+			}
+			if config.SSL != want {
+				t.Errorf("Server %s uses SSL %v with irc.ssl.enabled %v, wanted %v", server, config.SSL, globalSSL, want)
+			}
+			if config.SSL && config.TLSConfig.ServerName != server.Host {
+				t.Errorf("Server %s uses the TLS server name %q", server, config.TLSConfig.ServerName)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/lrstanley/girc"
+)
+
+const (
+	tagID       = "+cpthook/id"
+	tagModule   = "+cpthook/module"
+	tagSeverity = "+cpthook/severity"
+	tagURL      = "+cpthook/url"
+	tagReply    = "+draft/reply"
+	// tagPart is the number of the unit of a message, a line or a multiline batch
+	tagPart = "+cpthook/part"
+)
+
+// echoTimeout is how long we wait for the echo of the first line before sending the follow-up lines without a reply tag
+const echoTimeout = 5 * time.Second
+
+// messageTags builds the client-only tags which describe the event a message belongs to
+func messageTags(elem input.IRCMessage) girc.Tags {
+	tags := girc.Tags{}
+	values := map[string]string{
+		tagID:       elem.ID,
+		tagModule:   elem.Module,
+		tagSeverity: elem.Severity,
+		tagURL:      elem.URL,
+	}
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+		if err := tags.Set(key, value); err != nil {
+			log.WithFields(log.Fields{
+				"MsgID": elem.ID,
+				"tag":   key,
+			}).Debugf("Skipping message tag: %s", err)
+		}
+	}
+	return tags
+}
+
+// echoWaiters maps the ID of a message to a channel which receives the server
+// assigned msgid once the echo of the message arrives
+var echoWaiters = struct {
+	sync.Mutex
+	waiting map[string]chan string
+}{waiting: map[string]chan string{}}
+
+func awaitEcho(id string) chan string {
+	c := make(chan string, 1)
+	echoWaiters.Lock()
+	echoWaiters.waiting[id] = c
+	echoWaiters.Unlock()
+	return c
+}
+
+func cancelEcho(id string) {
+	echoWaiters.Lock()
+	delete(echoWaiters.waiting, id)
+	echoWaiters.Unlock()
+}
+
+// handleEcho confirms the delivery of echoed messages and passes their msgid to whoever is waiting for it
+func handleEcho(c *girc.Client, e girc.Event) {
+	if !e.Echo {
+		return
+	}
+	id, unit, ok := echoedUnit(e)
+	if !ok {
+		return
+	}
+	deliveries.confirm(id, unit)
+
+	// Only the first line is replied to
+	msgid, ok := e.Tags.Get("msgid")
+	if !ok || unit != 0 {
+		return
+	}
+
+	echoWaiters.Lock()
+	waiter, ok := echoWaiters.waiting[id]
+	delete(echoWaiters.waiting, id)
+	echoWaiters.Unlock()
+
+	if ok {
+		waiter <- msgid
+	}
+}
+
+// waitForMsgID blocks until the echo of the message arrived and returns its msgid.
+// An empty string is returned when the echo didn't arrive in time.
+func waitForMsgID(id string, waiter chan string) string {
+	select {
+	case msgid := <-waiter:
+		return msgid
+	case <-time.After(echoTimeout):
+		cancelEcho(id)
+		log.WithFields(log.Fields{
+			"MsgID": id,
+		}).Debug("Echo of message didn't arrive in time. Sending follow-up lines without reply tag")
+		return ""
+	}
+}
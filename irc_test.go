@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestStripFormatting(t *testing.T) {
+	channel := func(modes string) *girc.Channel {
+		c := &girc.Channel{Name: "#test", Modes: girc.NewCModes("beI,k,l,cimnpst", girc.DefaultPrefixes)}
+		if modes != "" {
+			c.Modes.Apply(c.Modes.Parse(modes, nil))
+		}
+		return c
+	}
+	colors := false
+	previous := channelConfigs
+	channelConfigs = map[string]channelConfig{"#nocolors": {Colors: &colors}}
+	t.Cleanup(func() {
+		channelConfigs = previous
+		plainText = false
+	})
+
+	tests := []struct {
+		plain   bool
+		target  string
+		channel *girc.Channel
+		strip   bool
+	}{
+		{false, "#test", channel("+nt"), false},
+		{false, "#test", channel("+cnt"), true},
+		{true, "#test", channel("+nt"), true},
+		{false, "#nocolors", channel("+nt"), true},
+		// Right after the JOIN, the channel or its modes aren't known yet
+		{false, "#test", nil, true},
+		{false, "#test", channel(""), true},
+		{false, "alice", nil, false},
+		{true, "alice", nil, true},
+	}
+	for _, test := range tests {
+		plainText = test.plain
+		var modes string
+		if test.channel != nil {
+			modes = test.channel.Modes.String()
+		}
+		if strip := stripFormatting(test.target, test.channel); strip != test.strip {
+			t.Errorf("stripFormatting(%q) with modes %q and plain text %v is %v, wanted %v", test.target, modes, test.plain, strip, test.strip)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+)
+
+const defaultTopicTemplate = "{{ .AlertsFiring }} alerts firing, {{ .HostsDown }} hosts down, {{ .PipelinesFailed }} pipelines failed"
+
+// topicCheckInterval is how often the topic is rendered and compared to the current topic
+const topicCheckInterval = 10 * time.Second
+
+// topicConfig configures a channel topic which shows the current status of the channel
+type topicConfig struct {
+	Template string        `mapstructure:"template"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// topicBoard keeps the topic of a channel in sync with the tracked status
+type topicBoard struct {
+	channel    string
+	template   *template.Template
+	interval   time.Duration
+	lastChange time.Time
+}
+
+func newTopicBoard(channel string, config topicConfig) (*topicBoard, error) {
+	if config.Template == "" {
+		config.Template = defaultTopicTemplate
+	}
+	if config.Interval == 0 {
+		config.Interval = time.Minute
+	}
+
+	t, err := template.New("topic").Funcs(template.FuncMap{"join": strings.Join}).Parse(config.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topicBoard{
+		channel:  channel,
+		template: t,
+		interval: config.Interval,
+	}, nil
+}
+
+// update renders the topic and changes it, if it differs from the current topic and
+// the last change is long enough ago
+func (b *topicBoard) update() {
+	channel := client.LookupChannel(b.channel)
+	if channel == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := b.template.Execute(&buf, input.Status.Summary(b.channel)); err != nil {
+		log.WithFields(log.Fields{
+			"channel": b.channel,
+		}).Errorf("Failed to render topic: %s", err)
+		return
+	}
+
+	topic := buf.String()
+	if channel.Topic == topic || time.Since(b.lastChange) < b.interval {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"channel": b.channel,
+		"topic":   topic,
+	}).Info("Updating channel topic")
+	client.Cmd.Topic(b.channel, topic)
+	b.lastChange = time.Now()
+}
+
+func (b *topicBoard) run() {
+	for range time.Tick(topicCheckInterval) {
+		if client.IsConnected() {
+			b.update()
+		}
+	}
+}
@@ -12,11 +12,13 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/fleaz/CptHook/input"
+	"github.com/fleaz/CptHook/output"
 	"github.com/spf13/viper"
 )
 
 var (
 	inputChannel = make(chan input.IRCMessage, 30)
+	sinks        = &output.Dispatcher{}
 	version      = "dev"
 	commit       = "none"
 	date         = time.Now().Format(time.RFC3339)
@@ -42,11 +44,43 @@ func configureLogLevel() {
 
 type Configuration struct {
 	Modules map[string]InputModule `yaml:"modules"`
+	Sinks   map[string]OutputSink  `yaml:"sinks"`
 }
 
 type InputModule struct {
-	Type     string `yaml:"type"`
-	Endpoint string `yaml:"endpoint"`
+	Type         string            `yaml:"type"`
+	Endpoint     string            `yaml:"endpoint"`
+	MessageType  string            `yaml:"message_type" mapstructure:"message_type"`
+	MessageTypes map[string]string `yaml:"message_types" mapstructure:"message_types"`
+}
+
+type OutputSink struct {
+	Type string `yaml:"type"`
+}
+
+func createSinkObject(name string) (output.Sink, error) {
+	var s output.Sink
+	var e error
+	switch name {
+	case "matrix":
+		s = &output.MatrixSink{}
+	case "slack":
+		s = &output.SlackSink{}
+	case "xmpp":
+		s = &output.XMPPSink{}
+	case "email":
+		s = &output.EmailSink{}
+	case "archive":
+		s = &output.ArchiveSink{}
+	case "telegram":
+		s = &output.TelegramSink{}
+	case "feed":
+		s = &output.FeedSink{}
+	default:
+		e = fmt.Errorf("ignoring configuration for unknown sink: %q", name)
+	}
+
+	return s, e
 }
 
 func createModuleObject(name string) (input.Module, error) {
@@ -78,6 +112,20 @@ func validateConfig(c Configuration) {
 		if blockConfig.Endpoint == "" {
 			foundErrors = append(foundErrors, fmt.Sprintf("Block %q is missing its endpoint", blockName))
 		}
+		if err := validateMessageType(blockConfig.MessageType); err != nil {
+			foundErrors = append(foundErrors, fmt.Sprintf("Block %q has an %s", blockName, err))
+		}
+		for event, t := range blockConfig.MessageTypes {
+			if err := validateMessageType(t); err != nil {
+				foundErrors = append(foundErrors, fmt.Sprintf("Block %q has an %s for event %q", blockName, err, event))
+			}
+		}
+	}
+
+	for sinkName, sinkConfig := range c.Sinks {
+		if sinkConfig.Type == "" {
+			foundErrors = append(foundErrors, fmt.Sprintf("Sink %q is missing its type", sinkName))
+		}
 	}
 
 	if len(foundErrors) > 0 {
@@ -92,6 +140,19 @@ func validateConfig(c Configuration) {
 
 }
 
+// forwardBlock passes the messages of a module block to the inputChannel and the sinks
+// and marks them with the name of the block, so block specific settings can be applied
+func forwardBlock(blockName string, blockChannel chan input.IRCMessage) {
+	for elem := range blockChannel {
+		elem.Block = blockName
+		if elem.Received.IsZero() {
+			elem.Received = time.Now()
+		}
+		sinks.Dispatch(elem)
+		inputChannel <- elem
+	}
+}
+
 func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.WithFields(log.Fields{
@@ -105,21 +166,11 @@ func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
-func ircCheckMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// tokenMiddleware rejects requests which don't carry the token
+func tokenMiddleware(token string, next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !client.IsConnected() {
-			log.WithFields(log.Fields{
-				"remote": r.RemoteAddr,
-				"uri":    r.URL,
-			}).Warn("IRC server is disconnected. Dropping incoming HTTP request")
-
-			// In some weird situations the IsConnected function detects that we are no longer connected,
-			// but the reconnect logic in irc.go doesn't detects the connection problem and won't reconnect
-			// Therefore if we detect that problem here, we Close() the connection manually and force a reconenct
-			client.Close()
-
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("IRC server disconnected"))
+		if !output.Authorized(r, token) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -154,6 +205,39 @@ func main() {
 
 	validateConfig(config)
 
+	if viper.IsSet("http.shortener") {
+		if err := input.Links.Configure(viper.GetString("http.public_url"), viper.Sub("http.shortener")); err != nil {
+			log.Fatalf("Invalid shortener configuration: %s", err)
+		}
+		log.Infof("Serving short links on %q", input.Links.Endpoint())
+		http.HandleFunc(input.Links.Endpoint(), input.Links.Handler())
+	}
+
+	if viper.IsSet("http.paste") {
+		if err := input.Pastes.Configure(viper.GetString("http.public_url"), viper.Sub("http.paste")); err != nil {
+			log.Fatalf("Invalid paste configuration: %s", err)
+		}
+		log.Infof("Serving pastes on %q", input.Pastes.Endpoint())
+		http.HandleFunc(input.Pastes.Endpoint(), input.Pastes.Handler())
+	}
+
+	for sinkName, sinkConfig := range config.Sinks {
+		sink, err := createSinkObject(sinkConfig.Type)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		if err := sink.Init(viper.Sub(fmt.Sprintf("sinks.%s", sinkName))); err != nil {
+			log.Fatalf("Invalid configuration for sink %q: %s", sinkName, err)
+		}
+		log.Infof("Loaded sink %q from config (Type %q)", sinkName, sinkConfig.Type)
+		sinks.Add(sinkName, sink)
+		if h, ok := sink.(output.HandlerSink); ok && h.Endpoint() != "" {
+			log.Infof("Serving endpoint of sink %q on %q", sinkName, h.Endpoint())
+			http.HandleFunc(h.Endpoint(), h.Handler())
+		}
+	}
+
 	for blockName, blockConfig := range config.Modules {
 		module, err := createModuleObject(blockConfig.Type)
 		if err != nil {
@@ -162,9 +246,45 @@ func main() {
 		}
 		log.Infof("Loaded block %q from config (Type %q, Endpoint %q)", blockName, blockConfig.Type, blockConfig.Endpoint)
 		configPath := fmt.Sprintf("modules.%s", blockName)
-		module.Init(viper.Sub(configPath), &inputChannel)
+		blockMessageTypes[blockName] = moduleMessageTypes{
+			Default: blockConfig.MessageType,
+			Events:  blockConfig.MessageTypes,
+		}
+		blockChannel := make(chan input.IRCMessage, 30)
+		go forwardBlock(blockName, blockChannel)
+		module.Init(viper.Sub(configPath), &blockChannel)
+		if commander, ok := module.(input.Commander); ok {
+			commands.register(blockName, commander.Commands())
+		}
 		channelList = append(channelList, module.GetChannelList()...)
-		http.HandleFunc(blockConfig.Endpoint, loggingMiddleware(ircCheckMiddleware(module.GetHandler())))
+		handler := bufferCheckMiddleware(module.GetHandler())
+		if viper.IsSet(configPath + ".relay") {
+			relay := &output.Relay{}
+			if err := relay.Init(viper.Sub(configPath + ".relay")); err != nil {
+				log.Fatalf("Invalid relay configuration for block %q: %s", blockName, err)
+			}
+			// Requests are relayed even when IRC is down
+			handler = relay.Middleware(handler)
+		}
+		http.HandleFunc(blockConfig.Endpoint, loggingMiddleware(handler))
+	}
+
+	if endpoint := viper.GetString("http.status_endpoint"); endpoint != "" {
+		token := viper.GetString("http.status_token")
+		if token == "" {
+			log.Fatal("http.status_token is required to serve the connection status")
+		}
+		log.Infof("Serving connection status on %q", endpoint)
+		http.Handle(endpoint, tokenMiddleware(token, connStatus))
+	}
+
+	if endpoint := viper.GetString("http.delivery_endpoint"); endpoint != "" {
+		token := viper.GetString("http.delivery_token")
+		if token == "" {
+			log.Fatal("http.delivery_token is required to serve the delivery state of messages")
+		}
+		log.Infof("Serving delivery state of messages on %q", endpoint)
+		http.HandleFunc(endpoint, tokenMiddleware(token, deliveries.handler(endpoint)))
 	}
 
 	// Start IRC connection
@@ -0,0 +1,348 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+const (
+	archiveFile = "archive.jsonl"
+	// rotated files are named archive-<time of rotation>.jsonl, so they sort chronologically
+	archiveRotatedPattern = "archive-*.jsonl"
+	archiveRotatedFormat  = "20060102T150405.000"
+)
+
+// ArchiveSink appends every message with the result of its IRC delivery to rotating
+// JSONL files and serves a search endpoint for them
+type ArchiveSink struct {
+	directory string
+	maxSize   int64
+	retention time.Duration
+	endpoint  string
+	token     string
+
+	records chan archiveRecord
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// archiveRecord is a single line of the archive
+type archiveRecord struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Module  string    `json:"module"`
+	Block   string    `json:"block"`
+	Channel string    `json:"channel"`
+	Lines   []string  `json:"lines"`
+	Result  string    `json:"result"`
+}
+
+func (a *ArchiveSink) Init(c *viper.Viper) error {
+	c.SetDefault("max_size", 10*1024*1024)
+	c.SetDefault("retention", 90*24*time.Hour)
+
+	a.directory = c.GetString("directory")
+	a.maxSize = c.GetInt64("max_size")
+	a.retention = c.GetDuration("retention")
+	a.endpoint = c.GetString("endpoint")
+	a.token = c.GetString("token")
+	a.records = make(chan archiveRecord, queueSize)
+
+	if a.directory == "" {
+		return fmt.Errorf("directory is required")
+	}
+	if a.endpoint != "" && a.token == "" {
+		return fmt.Errorf("the search endpoint requires a token")
+	}
+	if a.maxSize <= 0 {
+		return fmt.Errorf("max_size must be positive")
+	}
+	if err := os.MkdirAll(a.directory, 0750); err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	a.cleanup()
+
+	go func() {
+		for record := range a.records {
+			if err := a.write(record); err != nil {
+				log.WithFields(log.Fields{
+					"MsgID": record.ID,
+				}).Errorf("Failed to write message to archive: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Send does nothing, messages are archived once the result of their delivery is known
+func (a *ArchiveSink) Send(message input.IRCMessage) {}
+
+// Delivered queues the message for the archive
+func (a *ArchiveSink) Delivered(message input.IRCMessage, result string) {
+	var lines []string
+	for _, line := range message.Messages {
+		lines = append(lines, stripFormatting(line))
+	}
+	record := archiveRecord{
+		ID:      message.ID,
+		Time:    message.Received,
+		Module:  message.Module,
+		Block:   message.Block,
+		Channel: message.Channel,
+		Lines:   lines,
+		Result:  result,
+	}
+
+	select {
+	case a.records <- record:
+	default:
+		log.WithFields(log.Fields{
+			"MsgID": message.ID,
+		}).Warn("Queue of archive is full. Dropping message")
+	}
+}
+
+// open opens the current archive file for appending
+func (a *ArchiveSink) open() error {
+	f, err := os.OpenFile(filepath.Join(a.directory, archiveFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file = f
+	a.size = info.Size()
+	return nil
+}
+
+// write appends a record to the current file and rotates the file before it grows above max_size
+func (a *ArchiveSink) write(record archiveRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and starts a new one. The caller has to hold the lock.
+func (a *ArchiveSink) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	// Several rotations can happen within a millisecond. Later names have to sort after
+	// the earlier ones, so the time is moved forward until the name is free.
+	var rotated string
+	for t := time.Now().UTC(); ; t = t.Add(time.Millisecond) {
+		rotated = filepath.Join(a.directory, "archive-"+t.Format(archiveRotatedFormat)+".jsonl")
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+	}
+	if err := os.Rename(filepath.Join(a.directory, archiveFile), rotated); err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	go a.cleanup()
+	return nil
+}
+
+// rotatedFiles returns the rotated files, oldest first
+func (a *ArchiveSink) rotatedFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(a.directory, archiveRotatedPattern))
+	sort.Strings(files)
+	return files
+}
+
+// cleanup removes rotated files which were last written before the retention
+func (a *ArchiveSink) cleanup() {
+	if a.retention <= 0 {
+		return
+	}
+	for _, file := range a.rotatedFiles() {
+		info, err := os.Stat(file)
+		if err != nil || time.Since(info.ModTime()) <= a.retention {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			log.Warnf("Failed to remove old archive file: %s", err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"file": file,
+		}).Info("Removed old archive file")
+	}
+}
+
+// archiveQuery are the filters of a search
+type archiveQuery struct {
+	from    time.Time
+	to      time.Time
+	channel string
+	module  string
+	text    string
+	limit   int
+}
+
+func (q archiveQuery) matches(r archiveRecord) bool {
+	if !q.from.IsZero() && r.Time.Before(q.from) {
+		return false
+	}
+	if !q.to.IsZero() && r.Time.After(q.to) {
+		return false
+	}
+	if q.channel != "" && !strings.EqualFold(r.Channel, q.channel) {
+		return false
+	}
+	if q.module != "" && !strings.EqualFold(r.Module, q.module) && !strings.EqualFold(r.Block, q.module) {
+		return false
+	}
+	if q.text != "" && !strings.Contains(strings.ToLower(strings.Join(r.Lines, "\n")), q.text) {
+		return false
+	}
+	return true
+}
+
+// archiveSnapshot is an archive file opened for a search. Only the first size bytes
+// are read, as the current file may be written while it is searched.
+type archiveSnapshot struct {
+	file *os.File
+	size int64
+}
+
+// snapshot opens all files which may contain matches. The files are opened while
+// holding the lock, so they stay readable when the archive is rotated during a search.
+func (a *ArchiveSink) snapshot(q archiveQuery) []archiveSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var snapshots []archiveSnapshot
+	files := append(a.rotatedFiles(), filepath.Join(a.directory, archiveFile))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		info, err := f.Stat()
+		// Files which were last written before the start of the range can't contain matches
+		if err != nil || (!q.from.IsZero() && info.ModTime().Before(q.from)) {
+			f.Close()
+			continue
+		}
+		snapshots = append(snapshots, archiveSnapshot{file: f, size: info.Size()})
+	}
+	return snapshots
+}
+
+// search returns the newest records matching the query, oldest first
+func (a *ArchiveSink) search(q archiveQuery) []archiveRecord {
+	var results []archiveRecord
+	for _, s := range a.snapshot(q) {
+		reader := bufio.NewReader(io.LimitReader(s.file, s.size))
+		for {
+			line, err := reader.ReadBytes('\n')
+			var r archiveRecord
+			if len(line) > 0 && json.Unmarshal(line, &r) == nil && q.matches(r) {
+				results = append(results, r)
+				if len(results) > q.limit {
+					results = results[1:]
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"file": s.file.Name(),
+				}).Errorf("Failed to search archive file: %s", err)
+				break
+			}
+		}
+		s.file.Close()
+	}
+	return results
+}
+
+// Endpoint returns the path of the search endpoint, empty if it is disabled
+func (a *ArchiveSink) Endpoint() string {
+	return a.endpoint
+}
+
+// Handler searches the archive. All parameters are optional: from and to (RFC 3339),
+// channel, module (module type or block name), text and limit.
+func (a *ArchiveSink) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !Authorized(r, a.token) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		params := r.URL.Query()
+		q := archiveQuery{
+			channel: params.Get("channel"),
+			module:  params.Get("module"),
+			text:    strings.ToLower(params.Get("text")),
+			limit:   100,
+		}
+		for name, t := range map[string]*time.Time{"from": &q.from, "to": &q.to} {
+			if v := params.Get(name); v != "" {
+				parsed, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s, expected RFC 3339", name), http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+		}
+		if v := params.Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit <= 0 || limit > 1000 {
+				http.Error(w, "Invalid limit, must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+			q.limit = limit
+		}
+
+		results := a.search(q)
+		if results == nil {
+			results = []archiveRecord{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
@@ -0,0 +1,143 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+func TestArchiveSink(t *testing.T) {
+	dir := t.TempDir()
+	config := viper.New()
+	config.Set("directory", dir)
+	config.Set("max_size", 300)
+	config.Set("endpoint", "/archive")
+	config.Set("token", "secret")
+
+	sink := &ArchiveSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are always written after they were received
+	start := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Hour)
+	messages := []struct {
+		message input.IRCMessage
+		result  string
+	}{
+		{input.IRCMessage{ID: "AAAAAA", Module: "icinga2", Block: "icinga", Channel: "#infra", Messages: []string{"Host \x02web1\x02 is DOWN"}}, "delivered"},
+		{input.IRCMessage{ID: "BBBBBB", Module: "gitlab", Block: "gitlab-ci", Channel: "#ci", Messages: []string{"Pipeline failed"}}, "failed"},
+		{input.IRCMessage{ID: "CCCCCC", Module: "icinga2", Block: "icinga", Channel: "#infra", Messages: []string{"Host web1 is UP"}}, "sent"},
+		{input.IRCMessage{ID: "DDDDDD", Module: "icinga2", Block: "icinga", Channel: "#INFRA", Messages: []string{"Host web2 is DOWN"}}, "delivered"},
+	}
+	for i, m := range messages {
+		record := archiveRecord{
+			ID:      m.message.ID,
+			Time:    start.Add(time.Duration(i) * time.Hour),
+			Module:  m.message.Module,
+			Block:   m.message.Block,
+			Channel: m.message.Channel,
+			Lines:   []string{stripFormatting(m.message.Messages[0])},
+			Result:  m.result,
+		}
+		if err := sink.write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, archiveRotatedPattern)); len(files) == 0 {
+		t.Error("Archive wasn't rotated")
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"AAAAAA", "BBBBBB", "CCCCCC", "DDDDDD"}},
+		{"?channel=%23infra", []string{"AAAAAA", "CCCCCC", "DDDDDD"}},
+		{"?module=gitlab", []string{"BBBBBB"}},
+		{"?module=icinga&text=down", []string{"AAAAAA", "DDDDDD"}},
+		{"?from=" + start.Add(time.Hour).Format(time.RFC3339) + "&to=" + start.Add(2*time.Hour).Format(time.RFC3339), []string{"BBBBBB", "CCCCCC"}},
+		{"?limit=1", []string{"DDDDDD"}},
+		{"?text=nothing", []string{}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/archive"+test.query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		sink.Handler()(w, req)
+
+		var records []archiveRecord
+		if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+			t.Fatalf("Query %q returned invalid JSON: %s", test.query, err)
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, r.ID)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("Query %q returned %v, wanted %v", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("Query %q returned %v, wanted %v", test.query, got, test.want)
+				break
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	sink.Handler()(w, httptest.NewRequest("GET", "/archive?token=secret&from=yesterday", nil))
+	if w.Code != 400 {
+		t.Errorf("Invalid time returned status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	sink.Handler()(w, httptest.NewRequest("GET", "/archive?token=wrong", nil))
+	if w.Code != 401 {
+		t.Errorf("Wrong token returned status %d", w.Code)
+	}
+}
+
+func TestArchiveRotation(t *testing.T) {
+	dir := t.TempDir()
+	config := viper.New()
+	config.Set("directory", dir)
+	config.Set("max_size", 100)
+
+	sink := &ArchiveSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every record rotates the file, several times within the same millisecond. The
+	// last record is longer than the buffer of a bufio.Scanner.
+	ids := []string{"AAAAAA", "BBBBBB", "CCCCCC", "DDDDDD", "EEEEEE"}
+	for i, id := range ids {
+		line := "Host web1 is DOWN"
+		if i == len(ids)-1 {
+			line = strings.Repeat("x", 2*1024*1024)
+		}
+		if err := sink.write(archiveRecord{ID: id, Time: time.Now(), Lines: []string{line}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, archiveRotatedPattern)); len(files) != len(ids)-1 {
+		t.Errorf("Archive has %d rotated files, wanted %d", len(files), len(ids)-1)
+	}
+	var got []string
+	for _, r := range sink.search(archiveQuery{limit: 100}) {
+		got = append(got, r.ID)
+	}
+	if strings.Join(got, " ") != strings.Join(ids, " ") {
+		t.Errorf("Search returned %v, wanted %v", got, ids)
+	}
+}
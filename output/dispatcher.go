@@ -0,0 +1,58 @@
+package output
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+)
+
+// queueSize is the number of messages which can wait for a single sink
+const queueSize = 100
+
+// Dispatcher passes messages to all configured sinks. Every sink has its own queue, so
+// a slow sink delays neither the other sinks nor IRC.
+type Dispatcher struct {
+	queues    map[string]chan input.IRCMessage
+	observers []DeliveryObserver
+}
+
+// Add starts a queue for the sink with the given block name
+func (d *Dispatcher) Add(name string, sink Sink) {
+	if d.queues == nil {
+		d.queues = map[string]chan input.IRCMessage{}
+	}
+	queue := make(chan input.IRCMessage, queueSize)
+	d.queues[name] = queue
+	if observer, ok := sink.(DeliveryObserver); ok {
+		d.observers = append(d.observers, observer)
+	}
+
+	go func() {
+		for message := range queue {
+			sink.Send(message)
+		}
+	}()
+}
+
+// Dispatch queues the message for every sink. The message is dropped for sinks whose
+// queue is full.
+func (d *Dispatcher) Dispatch(message input.IRCMessage) {
+	for name, queue := range d.queues {
+		select {
+		case queue <- message:
+		default:
+			log.WithFields(log.Fields{
+				"MsgID": message.ID,
+				"sink":  name,
+			}).Warn("Queue of sink is full. Dropping message")
+		}
+	}
+}
+
+// Delivered passes the result of the IRC delivery of a message to the sinks which observe
+// deliveries. Observers must not block.
+func (d *Dispatcher) Delivered(message input.IRCMessage, result string) {
+	for _, observer := range d.observers {
+		observer.Delivered(message, result)
+	}
+}
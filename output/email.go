@@ -0,0 +1,312 @@
+package output
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// EmailSink collects messages per channel or module and sends them as digest email
+// once per window
+type EmailSink struct {
+	host        string
+	port        int
+	username    string
+	password    string
+	security    string
+	from        string
+	window      time.Duration
+	maxMessages int
+	mapping     emailMapping
+
+	mu      sync.Mutex
+	digests map[string]*digest
+}
+
+// emailMapping maps IRC channels and modules to recipients
+type emailMapping struct {
+	channelMapping `mapstructure:",squash"`
+	// Modules are matched by block name or module type
+	Modules map[string][]string `mapstructure:"modules"`
+}
+
+// digest are the messages collected for one channel or module
+type digest struct {
+	Title      string
+	recipients []string
+	Entries    []digestEntry
+	Dropped    int
+}
+
+type digestEntry struct {
+	Time    time.Time
+	Channel string
+	Module  string
+	Plain   string
+	HTML    template.HTML
+}
+
+const digestHTMLString = `<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{ .Title }}</h2>
+{{ if .Dropped }}<p><i>{{ .Dropped }} older messages were dropped</i></p>{{ end }}
+<table cellpadding="4">
+{{ range .Entries }}<tr><td valign="top" style="white-space: nowrap; color: #7f7f7f">{{ .Time.Format "2006-01-02 15:04" }}</td><td valign="top">{{ .Channel }}</td><td>{{ .HTML }}</td></tr>
+{{ end }}</table>
+</body></html>`
+
+const digestTextString = `{{ .Title }}
+{{ if .Dropped }}
+{{ .Dropped }} older messages were dropped
+{{ end }}{{ range .Entries }}
+[{{ .Time.Format "2006-01-02 15:04" }}] {{ .Channel }}
+{{ .Plain }}
+{{ end }}`
+
+var digestHTMLTemplate = template.Must(template.New("digest html").Parse(digestHTMLString))
+var digestTextTemplate = texttemplate.Must(texttemplate.New("digest text").Parse(digestTextString))
+
+func (e *EmailSink) Init(c *viper.Viper) error {
+	c.SetDefault("port", 587)
+	c.SetDefault("security", "starttls")
+	c.SetDefault("window", 12*time.Hour)
+	c.SetDefault("max_messages", 1000)
+
+	e.host = c.GetString("host")
+	e.port = c.GetInt("port")
+	e.username = c.GetString("username")
+	e.password = c.GetString("password")
+	e.security = c.GetString("security")
+	e.from = c.GetString("from")
+	e.window = c.GetDuration("window")
+	e.maxMessages = c.GetInt("max_messages")
+	e.digests = map[string]*digest{}
+
+	if e.host == "" || e.from == "" {
+		return fmt.Errorf("host and from are required")
+	}
+	switch e.security {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("security must be starttls, tls or none")
+	}
+	if e.window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if err := c.Unmarshal(&e.mapping); err != nil {
+		return err
+	}
+
+	go e.run()
+	return nil
+}
+
+// Send adds the message to the digests of its channel and module
+func (e *EmailSink) Send(message input.IRCMessage) {
+	entry := digestEntry{
+		Time:    message.Received,
+		Channel: message.Channel,
+		Module:  message.Module,
+		Plain:   renderPlain(message.Messages),
+		HTML:    template.HTML(renderHTML(message.Messages)),
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if recipients := e.mapping.targets(message.Channel); len(recipients) > 0 {
+		e.add("channel "+strings.ToLower(message.Channel), "Messages in "+message.Channel, recipients, entry)
+	}
+	for _, name := range []string{message.Block, message.Module} {
+		if recipients := e.mapping.Modules[strings.ToLower(name)]; name != "" && len(recipients) > 0 {
+			e.add("module "+strings.ToLower(name), "Messages from "+name, recipients, entry)
+			break
+		}
+	}
+}
+
+// add appends an entry to a digest. The caller has to hold the lock.
+func (e *EmailSink) add(key, title string, recipients []string, entry digestEntry) {
+	d, ok := e.digests[key]
+	if !ok {
+		d = &digest{Title: title, recipients: recipients}
+		e.digests[key] = d
+	}
+	d.Entries = append(d.Entries, entry)
+	e.limit(d)
+}
+
+// limit drops the oldest entries of a digest above max_messages
+func (e *EmailSink) limit(d *digest) {
+	if len(d.Entries) > e.maxMessages {
+		d.Dropped += len(d.Entries) - e.maxMessages
+		d.Entries = d.Entries[len(d.Entries)-e.maxMessages:]
+	}
+}
+
+// run sends the digests at the end of every window. Windows are aligned to UTC, e.g. a
+// window of 24h ends at midnight UTC.
+func (e *EmailSink) run() {
+	for {
+		next := time.Now().Truncate(e.window).Add(e.window)
+		time.Sleep(time.Until(next))
+		e.flush()
+	}
+}
+
+// flush sends all digests with messages. Digests which can't be sent are kept for the next window.
+func (e *EmailSink) flush() {
+	e.mu.Lock()
+	digests := e.digests
+	e.digests = map[string]*digest{}
+	e.mu.Unlock()
+
+	for key, d := range digests {
+		if len(d.Entries) == 0 {
+			continue
+		}
+		if err := e.sendDigest(d); err != nil {
+			log.WithFields(log.Fields{
+				"digest":   d.Title,
+				"messages": len(d.Entries),
+			}).Errorf("Failed to send digest email, retrying in the next window: %s", err)
+
+			// Put the entries in front of the ones which arrived in the meantime
+			e.mu.Lock()
+			if current, ok := e.digests[key]; ok {
+				d.Entries = append(d.Entries, current.Entries...)
+				d.Dropped += current.Dropped
+			}
+			e.limit(d)
+			e.digests[key] = d
+			e.mu.Unlock()
+			continue
+		}
+		log.WithFields(log.Fields{
+			"digest":     d.Title,
+			"messages":   len(d.Entries),
+			"recipients": d.recipients,
+		}).Info("Sent digest email")
+	}
+}
+
+func (e *EmailSink) sendDigest(d *digest) error {
+	message, err := e.buildEmail(d)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	var client *smtp.Client
+	if e.security == "tls" {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: e.host})
+		if err != nil {
+			return err
+		}
+		client, err = smtp.NewClient(conn, e.host)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		client, err = smtp.Dial(addr)
+		if err != nil {
+			return err
+		}
+	}
+	defer client.Close()
+
+	if e.security == "starttls" {
+		if err := client.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return err
+		}
+	}
+	if e.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return err
+	}
+	for _, r := range d.recipients {
+		if err := client.Rcpt(r); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildEmail creates a multipart email with a plain text and a HTML version of the digest
+func (e *EmailSink) buildEmail(d *digest) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, d); err != nil {
+		return nil, err
+	}
+	if err := digestHTMLTemplate.Execute(&html, d); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	mw.Close()
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", e.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(d.recipients, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("[CptHook] %s (%d)", d.Title, len(d.Entries))))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%x@cpthook>\r\n", id)
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
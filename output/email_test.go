@@ -0,0 +1,153 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// fakeSMTPServer accepts clients and passes the data of every mail it receives to mails
+func fakeSMTPServer(listener net.Listener, mails chan string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			fmt.Fprintf(conn, "220 localhost ESMTP\r\n")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				switch command := strings.ToUpper(strings.TrimSpace(line)); {
+				case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+					fmt.Fprintf(conn, "250 localhost\r\n")
+				case command == "DATA":
+					fmt.Fprintf(conn, "354 Go ahead\r\n")
+					var data strings.Builder
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if line == ".\r\n" {
+							break
+						}
+						data.WriteString(strings.TrimPrefix(line, "."))
+					}
+					mails <- data.String()
+					fmt.Fprintf(conn, "250 OK\r\n")
+				case command == "QUIT":
+					fmt.Fprintf(conn, "221 Bye\r\n")
+					return
+				default:
+					fmt.Fprintf(conn, "250 OK\r\n")
+				}
+			}
+		}()
+	}
+}
+
+func TestEmailSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	mails := make(chan string, 10)
+	go fakeSMTPServer(listener, mails)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	config := viper.New()
+	config.Set("host", host)
+	config.Set("port", port)
+	config.Set("security", "none")
+	config.Set("from", "cpthook@example.com")
+	config.Set("channels", map[string][]string{"#infra": {"ops@example.com"}})
+
+	sink := &EmailSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The digest shows when the messages were received, not when the sink got them
+	received := time.Date(2024, 5, 1, 13, 37, 0, 0, time.Local)
+	sink.Send(input.IRCMessage{ID: "AB12CD", Channel: "#infra", Messages: []string{"Host \x02web1\x02 is \x0304DOWN\x03"}, Received: received})
+	sink.Send(input.IRCMessage{ID: "EF34GH", Channel: "#infra", Messages: []string{"Host web1 is UP"}, Received: received})
+	sink.Send(input.IRCMessage{ID: "IJ56KL", Channel: "#other", Messages: []string{"Not mapped"}, Received: received})
+	sink.flush()
+
+	var data string
+	select {
+	case data = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP server didn't receive a digest")
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("To"); got != "ops@example.com" {
+		t.Errorf("Digest has wrong recipient: %q", got)
+	}
+	if got := msg.Header.Get("Subject"); got != "[CptHook] Messages in #infra (2)" {
+		t.Errorf("Digest has wrong subject: %q", got)
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(quotedprintable.NewReader(part))
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[mediaType] = string(content)
+	}
+
+	for mediaType, wanted := range map[string][]string{
+		"text/plain": {"[2024-05-01 13:37] #infra", "Host web1 is DOWN", "Host web1 is UP"},
+		"text/html":  {`Host <b>web1</b> is <font color="#ff0000" data-mx-color="#ff0000">DOWN</font>`, "Host web1 is UP"},
+	} {
+		for _, want := range wanted {
+			if !strings.Contains(parts[mediaType], want) {
+				t.Errorf("%s part doesn't contain %q:\n%s", mediaType, want, parts[mediaType])
+			}
+		}
+		if strings.Contains(parts[mediaType], "Not mapped") {
+			t.Errorf("%s part contains a message of an unmapped channel", mediaType)
+		}
+	}
+
+	// Empty digests aren't sent
+	sink.flush()
+	select {
+	case <-mails:
+		t.Error("SMTP server received an empty digest")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,177 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// feedKeepalive is the interval of comments which keep idle connections open
+const feedKeepalive = 30 * time.Second
+
+// FeedSink streams the messages delivered to IRC as Server-Sent Events
+type FeedSink struct {
+	endpoint   string
+	token      string
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[chan feedEvent]bool
+}
+
+// feedEvent is the data of a single event
+type feedEvent struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	Module  string    `json:"module"`
+	Block   string    `json:"block"`
+	Plain   string    `json:"plain"`
+	HTML    string    `json:"html"`
+}
+
+func (f *FeedSink) Init(c *viper.Viper) error {
+	c.SetDefault("max_clients", 50)
+
+	f.endpoint = c.GetString("endpoint")
+	f.token = c.GetString("token")
+	f.maxClients = c.GetInt("max_clients")
+	f.clients = map[chan feedEvent]bool{}
+
+	if f.endpoint == "" || f.token == "" {
+		return fmt.Errorf("endpoint and token are required")
+	}
+	return nil
+}
+
+// Send does nothing, only messages which were delivered to IRC are streamed
+func (f *FeedSink) Send(message input.IRCMessage) {}
+
+// Delivered passes the message to all connected clients, unless it never reached IRC.
+// Clients which can't keep up miss messages instead of delaying the others.
+func (f *FeedSink) Delivered(message input.IRCMessage, result string) {
+	if result == "failed" || result == "dropped" {
+		return
+	}
+	event := feedEvent{
+		ID:      message.ID,
+		Time:    message.Received,
+		Channel: message.Channel,
+		Module:  message.Module,
+		Block:   message.Block,
+		Plain:   renderPlain(message.Messages),
+		HTML:    renderHTML(message.Messages),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for client := range f.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+}
+
+// Endpoint returns the path of the feed
+func (f *FeedSink) Endpoint() string {
+	return f.endpoint
+}
+
+// subscribe registers a new client, nil if there are too many clients
+func (f *FeedSink) subscribe() chan feedEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.clients) >= f.maxClients {
+		return nil
+	}
+	client := make(chan feedEvent, queueSize)
+	f.clients[client] = true
+	return client
+}
+
+func (f *FeedSink) unsubscribe(client chan feedEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.clients, client)
+}
+
+// Handler streams the events. The optional query parameters channel and module (module
+// type or block name) can be given multiple times to filter the events.
+func (f *FeedSink) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !Authorized(r, f.token) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		channels := r.URL.Query()["channel"]
+		modules := r.URL.Query()["module"]
+
+		client := f.subscribe()
+		if client == nil {
+			http.Error(w, "Too many clients", http.StatusServiceUnavailable)
+			return
+		}
+		defer f.unsubscribe(client)
+
+		// The stream is open much longer than the write timeout of the server
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		log.WithFields(log.Fields{
+			"remote": r.RemoteAddr,
+		}).Debug("Client connected to feed")
+
+		keepalive := time.NewTicker(feedKeepalive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case event := <-client:
+				if !matchesAny(channels, event.Channel) || !matchesAny(modules, event.Module, event.Block) {
+					continue
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", event.ID, data)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// matchesAny reports if one of the values matches one of the filters. No filters match everything.
+func matchesAny(filters []string, values ...string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, filter := range filters {
+		for _, value := range values {
+			if strings.EqualFold(filter, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
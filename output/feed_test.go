@@ -0,0 +1,93 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+func TestFeedSink(t *testing.T) {
+	config := viper.New()
+	config.Set("endpoint", "/feed")
+	config.Set("token", "secret")
+
+	sink := &FeedSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(sink.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/feed?token=wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Feed returned status %d for a wrong token", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/feed?channel=%23infra&module=icinga", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Feed returned wrong content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	// Wait for the client to be subscribed
+	for i := 0; i < 100; i++ {
+		sink.mu.Lock()
+		n := len(sink.clients)
+		sink.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	received := time.Now()
+	sink.Delivered(input.IRCMessage{ID: "AAAAAA", Module: "gitlab", Block: "gitlab", Channel: "#infra", Messages: []string{"Wrong module"}, Received: received}, "delivered")
+	sink.Delivered(input.IRCMessage{ID: "BBBBBB", Module: "icinga2", Block: "icinga", Channel: "#other", Messages: []string{"Wrong channel"}, Received: received}, "delivered")
+	sink.Delivered(input.IRCMessage{ID: "CCCCCC", Module: "icinga2", Block: "icinga", Channel: "#infra", Messages: []string{"Not delivered"}, Received: received}, "failed")
+	sink.Delivered(input.IRCMessage{ID: "DDDDDD", Module: "icinga2", Block: "icinga", Channel: "#INFRA", Messages: []string{"Host \x02web1\x02 is DOWN"}, Received: received}, "sent")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	var event feedEvent
+	for event.ID == "" {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "data: ") {
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+					t.Fatal(err)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Feed didn't send an event")
+		}
+	}
+
+	if event.ID != "DDDDDD" {
+		t.Errorf("Feed sent the wrong message %q", event.ID)
+	}
+	if event.Plain != "Host web1 is DOWN" || event.HTML != "Host <b>web1</b> is DOWN" {
+		t.Errorf("Feed sent wrong texts: %+v", event)
+	}
+}
@@ -0,0 +1,271 @@
+package output
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// mIRC formatting codes
+const (
+	ircBold          = '\x02'
+	ircColor         = '\x03'
+	ircHexColor      = '\x04'
+	ircReset         = '\x0F'
+	ircMonospace     = '\x11'
+	ircReverse       = '\x16'
+	ircItalic        = '\x1D'
+	ircStrikethrough = '\x1E'
+	ircUnderline     = '\x1F'
+)
+
+// ircColors are the RGB values of the 16 standard mIRC colors
+var ircColors = []string{
+	"#ffffff", "#000000", "#00007f", "#009300", "#ff0000", "#7f0000", "#9c009c", "#fc7f00",
+	"#ffff00", "#00fc00", "#009393", "#00ffff", "#0000fc", "#ff00ff", "#7f7f7f", "#d2d2d2",
+}
+
+// style is the formatting of a segment of text
+type style struct {
+	bold      bool
+	italic    bool
+	underline bool
+	strike    bool
+	monospace bool
+	// color is the foreground color as "#rrggbb" or empty
+	color string
+}
+
+// segment is a piece of text with the same formatting
+type segment struct {
+	text  string
+	style style
+}
+
+// parseFormatting splits a line with mIRC formatting codes into segments
+func parseFormatting(line string) []segment {
+	var segments []segment
+	var current style
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			segments = append(segments, segment{text: text.String(), style: current})
+			text.Reset()
+		}
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch r {
+		case ircBold:
+			flush()
+			current.bold = !current.bold
+		case ircItalic:
+			flush()
+			current.italic = !current.italic
+		case ircUnderline:
+			flush()
+			current.underline = !current.underline
+		case ircStrikethrough:
+			flush()
+			current.strike = !current.strike
+		case ircMonospace:
+			flush()
+			current.monospace = !current.monospace
+		case ircReset:
+			flush()
+			current = style{}
+		case ircReverse:
+			// Reversing the colors can't be represented, ignore it
+		case ircColor:
+			flush()
+			fg, n := readDigits(runes[i+1:])
+			i += n
+			if n > 0 && i+2 < len(runes) && runes[i+1] == ',' && isDigit(runes[i+2]) {
+				_, m := readDigits(runes[i+2:])
+				i += m + 1
+			}
+			current.color = ""
+			if c, err := strconv.Atoi(fg); err == nil && c < len(ircColors) {
+				current.color = ircColors[c]
+			}
+		case ircHexColor:
+			flush()
+			fg := readHex(runes[i+1:])
+			i += len(fg)
+			if fg != "" && i+7 < len(runes) && runes[i+1] == ',' && readHex(runes[i+2:]) != "" {
+				i += 7
+			}
+			current.color = ""
+			if fg != "" {
+				current.color = "#" + strings.ToLower(fg)
+			}
+		default:
+			if r < 0x20 {
+				// Other control characters are dropped
+				continue
+			}
+			text.WriteRune(r)
+		}
+	}
+	flush()
+	return segments
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// readDigits reads up to two digits of a color code
+func readDigits(runes []rune) (string, int) {
+	n := 0
+	for n < len(runes) && n < 2 && isDigit(runes[n]) {
+		n++
+	}
+	return string(runes[:n]), n
+}
+
+// readHex reads the six digits of a hex color code
+func readHex(runes []rune) string {
+	if len(runes) < 6 {
+		return ""
+	}
+	for _, r := range runes[:6] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return ""
+		}
+	}
+	return string(runes[:6])
+}
+
+// stripFormatting removes all formatting codes from a line
+func stripFormatting(line string) string {
+	var plain strings.Builder
+	for _, s := range parseFormatting(line) {
+		plain.WriteString(s.text)
+	}
+	return plain.String()
+}
+
+// renderer converts the formatting of a line into the markup of a sink
+type renderer struct {
+	escape    func(string) string
+	bold      [2]string
+	italic    [2]string
+	underline [2]string
+	strike    [2]string
+	monospace [2]string
+	// color returns the opening and closing markup for a color, nil if colors aren't supported
+	color func(string) [2]string
+}
+
+// render converts a line. Every segment is wrapped on its own, so the markup is always
+// properly nested.
+func (r renderer) render(line string) string {
+	var out strings.Builder
+	for _, s := range parseFormatting(line) {
+		var open, close []string
+		wrap := func(enabled bool, markup [2]string) {
+			if enabled && markup[0] != "" {
+				open = append(open, markup[0])
+				close = append([]string{markup[1]}, close...)
+			}
+		}
+		if r.color != nil && s.style.color != "" {
+			wrap(true, r.color(s.style.color))
+		}
+		wrap(s.style.bold, r.bold)
+		wrap(s.style.italic, r.italic)
+		wrap(s.style.underline, r.underline)
+		wrap(s.style.strike, r.strike)
+		wrap(s.style.monospace, r.monospace)
+
+		// Whitespace is kept outside of the markup, as Markdown doesn't allow it inside
+		text := strings.TrimSpace(s.text)
+		start := strings.Index(s.text, text)
+		out.WriteString(s.text[:start])
+		if text != "" {
+			out.WriteString(strings.Join(open, ""))
+			out.WriteString(r.escape(text))
+			out.WriteString(strings.Join(close, ""))
+		}
+		out.WriteString(s.text[start+len(text):])
+	}
+	return out.String()
+}
+
+// renderLines converts the lines of a message and joins them with sep
+func (r renderer) renderLines(lines []string, sep string) string {
+	var rendered []string
+	for _, line := range lines {
+		rendered = append(rendered, r.render(line))
+	}
+	return strings.Join(rendered, sep)
+}
+
+// htmlRenderer creates HTML as understood by Matrix clients
+var htmlRenderer = renderer{
+	escape:    html.EscapeString,
+	bold:      [2]string{"<b>", "</b>"},
+	italic:    [2]string{"<i>", "</i>"},
+	underline: [2]string{"<u>", "</u>"},
+	strike:    [2]string{"<del>", "</del>"},
+	monospace: [2]string{"<code>", "</code>"},
+	color: func(color string) [2]string {
+		return [2]string{`<font color="` + color + `" data-mx-color="` + color + `">`, "</font>"}
+	},
+}
+
+// mrkdwnRenderer creates the Markdown variant used by Slack
+var mrkdwnRenderer = renderer{
+	escape:    strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace,
+	bold:      [2]string{"*", "*"},
+	italic:    [2]string{"_", "_"},
+	strike:    [2]string{"~", "~"},
+	monospace: [2]string{"`", "`"},
+}
+
+// markdownRenderer creates Markdown as used by Mattermost
+var markdownRenderer = renderer{
+	escape:    escapeMarkdown,
+	bold:      [2]string{"**", "**"},
+	italic:    [2]string{"_", "_"},
+	strike:    [2]string{"~~", "~~"},
+	monospace: [2]string{"`", "`"},
+}
+
+// escapeMarkdown escapes the Markdown syntax in text. URLs are left alone, so they are
+// still recognized as links.
+func escapeMarkdown(text string) string {
+	words := strings.Split(text, " ")
+	for i, word := range words {
+		if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+			continue
+		}
+		var escaped strings.Builder
+		for _, r := range word {
+			if strings.ContainsRune("\\`*_~[]<>#|", r) {
+				escaped.WriteRune('\\')
+			}
+			escaped.WriteRune(r)
+		}
+		words[i] = escaped.String()
+	}
+	return strings.Join(words, " ")
+}
+
+// renderHTML converts the lines of a message to HTML, separated by line breaks
+func renderHTML(lines []string) string {
+	return htmlRenderer.renderLines(lines, "<br>")
+}
+
+// renderPlain removes all formatting from the lines of a message and joins them with newlines
+func renderPlain(lines []string) string {
+	var plain []string
+	for _, line := range lines {
+		plain = append(plain, stripFormatting(line))
+	}
+	return strings.Join(plain, "\n")
+}
@@ -0,0 +1,66 @@
+package output
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// Sink defines a common interface for all CptHook output sinks. Sinks receive every
+// message the modules create, in addition to IRC.
+type Sink interface {
+	Init(c *viper.Viper) error
+	Send(message input.IRCMessage)
+}
+
+// DeliveryObserver is implemented by sinks which want to know the result of the IRC
+// delivery of a message, e.g. "sent", "delivered", "failed" or "dropped"
+type DeliveryObserver interface {
+	Delivered(message input.IRCMessage, result string)
+}
+
+// HandlerSink is implemented by sinks which serve an HTTP endpoint. The endpoint is
+// disabled when Endpoint returns an empty path.
+type HandlerSink interface {
+	Endpoint() string
+	Handler() http.HandlerFunc
+}
+
+// channelMapping maps IRC channels to the targets of a sink, e.g. Matrix rooms
+type channelMapping struct {
+	Channels map[string][]string `mapstructure:"channels"`
+}
+
+// targets returns the targets the messages of an IRC channel are sent to
+func (c channelMapping) targets(channel string) []string {
+	// viper lowercases all keys
+	return c.Channels[strings.ToLower(channel)]
+}
+
+// allTargets returns every configured target once
+func (c channelMapping) allTargets() []string {
+	var all []string
+	seen := map[string]bool{}
+	for _, targets := range c.Channels {
+		for _, t := range targets {
+			if !seen[t] {
+				seen[t] = true
+				all = append(all, t)
+			}
+		}
+	}
+	return all
+}
+
+// Authorized checks the token of a request to an HTTP endpoint. The token is given as
+// bearer token or as query parameter, because browsers can't set headers for EventSource.
+func Authorized(r *http.Request, token string) bool {
+	given := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		given = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,122 @@
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// retryClient sends HTTP requests and retries them on network errors, rate limits
+// (status 429) and server errors (status 5xx)
+type retryClient struct {
+	client  *http.Client
+	retries int
+	delay   time.Duration
+	// secrets are removed from logged URLs and returned errors, e.g. a token in the path
+	secrets []string
+}
+
+func newRetryClient(retries int) *retryClient {
+	return &retryClient{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		retries: retries,
+		delay:   time.Second,
+	}
+}
+
+// do sends the request created by newRequest and returns the body of the response.
+// A new request is created for every attempt, because the body can only be read once.
+func (c *retryClient) do(newRequest func() (*http.Request, error)) ([]byte, error) {
+	delay := c.delay
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		body, wait, err := c.attempt(req)
+		if err == nil {
+			return body, nil
+		}
+		if len(c.secrets) > 0 {
+			err = errors.New(c.redact(err.Error()))
+		}
+		if wait < 0 || attempt >= c.retries {
+			return body, err
+		}
+		if wait == 0 {
+			wait = delay
+			delay *= 2
+		}
+
+		log.WithFields(log.Fields{
+			"url":     c.redact(req.URL.Redacted()),
+			"attempt": attempt + 1,
+			"wait":    wait,
+		}).Warnf("HTTP request failed, retrying: %s", err)
+		time.Sleep(wait)
+	}
+}
+
+// redact removes the secrets from text
+func (c *retryClient) redact(text string) string {
+	for _, secret := range c.secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, "REDACTED")
+		}
+	}
+	return text
+}
+
+// attempt sends the request once. wait is negative when the request must not be
+// retried, otherwise it is the delay the server asked for, if any.
+func (c *retryClient) attempt(req *http.Request) (body []byte, wait time.Duration, err error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return body, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return body, retryAfter(resp, body), fmt.Errorf("rate limited (status %d)", resp.StatusCode)
+	case resp.StatusCode >= 500:
+		return body, 0, fmt.Errorf("server error (status %d): %s", resp.StatusCode, body)
+	default:
+		return body, -1, fmt.Errorf("request rejected (status %d): %s", resp.StatusCode, body)
+	}
+}
+
+// retryAfter returns how long a rate limited client has to wait. Besides the Retry-After
+// header, the delays in the bodies of the Matrix and Telegram APIs are understood.
+func retryAfter(resp *http.Response, body []byte) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	var limited struct {
+		RetryAfterMs int `json:"retry_after_ms"`
+		Parameters   struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if json.Unmarshal(body, &limited) == nil {
+		if limited.RetryAfterMs > 0 {
+			return time.Duration(limited.RetryAfterMs) * time.Millisecond
+		}
+		if limited.Parameters.RetryAfter > 0 {
+			return time.Duration(limited.Parameters.RetryAfter) * time.Second
+		}
+	}
+	return 0
+}
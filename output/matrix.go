@@ -0,0 +1,171 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// MatrixSink sends messages to Matrix rooms via the client-server API
+type MatrixSink struct {
+	homeserver  string
+	accessToken string
+	msgType     string
+	autoJoin    bool
+	mapping     channelMapping
+	client      *retryClient
+
+	mu      sync.Mutex
+	roomIDs map[string]string
+	joined  map[string]bool
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+func (m *MatrixSink) Init(c *viper.Viper) error {
+	c.SetDefault("msgtype", "m.notice")
+	c.SetDefault("auto_join", true)
+	c.SetDefault("retries", 3)
+
+	m.homeserver = strings.TrimSuffix(c.GetString("homeserver"), "/")
+	m.accessToken = c.GetString("access_token")
+	m.msgType = c.GetString("msgtype")
+	m.autoJoin = c.GetBool("auto_join")
+	m.client = newRetryClient(c.GetInt("retries"))
+	m.roomIDs = map[string]string{}
+	m.joined = map[string]bool{}
+
+	if m.homeserver == "" || m.accessToken == "" {
+		return fmt.Errorf("homeserver and access_token are required")
+	}
+	if m.msgType != "m.notice" && m.msgType != "m.text" {
+		return fmt.Errorf("msgtype must be m.notice or m.text")
+	}
+	if err := c.Unmarshal(&m.mapping); err != nil {
+		return err
+	}
+
+	if m.autoJoin {
+		go func() {
+			for _, room := range m.mapping.allTargets() {
+				if _, err := m.room(room); err != nil {
+					log.WithFields(log.Fields{
+						"room": room,
+					}).Errorf("Failed to join Matrix room: %s", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (m *MatrixSink) Send(message input.IRCMessage) {
+	content := matrixMessage{
+		MsgType:       m.msgType,
+		Body:          renderPlain(message.Messages),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: renderHTML(message.Messages),
+	}
+
+	for _, room := range m.mapping.targets(message.Channel) {
+		roomID, err := m.room(room)
+		if err == nil {
+			err = m.send(roomID, message.ID, content)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"MsgID": message.ID,
+				"room":  room,
+			}).Errorf("Failed to send message to Matrix: %s", err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"MsgID": message.ID,
+			"room":  room,
+		}).Debug("Sent message to Matrix")
+	}
+}
+
+// room returns the ID of a room given by ID or alias and joins it, if auto_join is enabled
+func (m *MatrixSink) room(room string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.autoJoin && !m.joined[room] {
+		// Joining returns the room ID for aliases as well
+		var joined struct {
+			RoomID string `json:"room_id"`
+		}
+		if err := m.request(http.MethodPost, "/join/"+url.PathEscape(room), struct{}{}, &joined); err != nil {
+			return "", err
+		}
+		m.joined[room] = true
+		m.roomIDs[room] = joined.RoomID
+	}
+
+	if !strings.HasPrefix(room, "#") {
+		return room, nil
+	}
+	if id, ok := m.roomIDs[room]; ok {
+		return id, nil
+	}
+
+	var resolved struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := m.request(http.MethodGet, "/directory/room/"+url.PathEscape(room), nil, &resolved); err != nil {
+		return "", err
+	}
+	m.roomIDs[room] = resolved.RoomID
+	return resolved.RoomID, nil
+}
+
+func (m *MatrixSink) send(roomID, messageID string, content matrixMessage) error {
+	// The transaction ID makes retries idempotent
+	txnID := fmt.Sprintf("cpthook-%s-%d", messageID, time.Now().UnixNano())
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), url.PathEscape(txnID))
+	return m.request(http.MethodPut, path, content, nil)
+}
+
+// request calls the client-server API and decodes the response into result, if given
+func (m *MatrixSink) request(method, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	response, err := m.client.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(method, m.homeserver+"/_matrix/client/v3"+path, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+m.accessToken)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		return json.Unmarshal(response, result)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+func TestMatrixSink(t *testing.T) {
+	var mu sync.Mutex
+	var sent []matrixMessage
+	var rooms []string
+
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/join/"):
+			w.Write([]byte(`{"room_id": "!abc:example.com"}`))
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"):
+			var m matrixMessage
+			json.NewDecoder(r.Body).Decode(&m)
+			sent = append(sent, m)
+			rooms = append(rooms, strings.Split(r.URL.Path, "/")[5])
+			w.Write([]byte(`{"event_id": "$1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer homeserver.Close()
+
+	config := viper.New()
+	config.Set("homeserver", homeserver.URL)
+	config.Set("access_token", "secret")
+	config.Set("auto_join", false)
+	config.Set("channels", map[string][]string{"#monitoring": {"!abc:example.com"}})
+
+	sink := &MatrixSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.Send(input.IRCMessage{
+		ID:       "AB12CD",
+		Channel:  "#Monitoring",
+		Messages: []string{"Host \x0312web1\x03 is \x02DOWN\x02", "→ 100% <packet loss>"},
+	})
+	sink.Send(input.IRCMessage{ID: "EF34GH", Channel: "#other", Messages: []string{"not mapped"}})
+
+	if len(sent) != 1 {
+		t.Fatalf("Matrix sink sent %d messages, wanted 1", len(sent))
+	}
+	if rooms[0] != "!abc:example.com" {
+		t.Errorf("Matrix sink sent to wrong room: got %q", rooms[0])
+	}
+	if want := "Host web1 is DOWN\n→ 100% <packet loss>"; sent[0].Body != want {
+		t.Errorf("Matrix sink sent wrong body: got %q wanted %q", sent[0].Body, want)
+	}
+	want := `Host <font color="#0000fc" data-mx-color="#0000fc">web1</font> is <b>DOWN</b><br>→ 100% &lt;packet loss&gt;`
+	if sent[0].FormattedBody != want {
+		t.Errorf("Matrix sink sent wrong formatted body: got %q wanted %q", sent[0].FormattedBody, want)
+	}
+}
@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/viper"
+)
+
+// maxRelayBody is the largest request body which is relayed
+const maxRelayBody = 10 * 1024 * 1024
+
+// Relay forwards the original requests of a module block to other webhook consumers.
+// Requests are relayed asynchronously and independently of the IRC delivery.
+type Relay struct {
+	targets []*relayTarget
+}
+
+// relayTarget is a single URL requests are relayed to
+type relayTarget struct {
+	URL string `mapstructure:"url"`
+	// Headers are forwarded in addition to Content-Type
+	Headers []string `mapstructure:"headers"`
+
+	client *retryClient
+	queue  chan relayRequest
+	// redacted is the URL without its secrets for the log
+	redacted string
+}
+
+// relayRequest is a copy of a received request
+type relayRequest struct {
+	body   []byte
+	header http.Header
+}
+
+// Init reads the relay configuration of a module block
+func (r *Relay) Init(c *viper.Viper) error {
+	c.SetDefault("retries", 3)
+
+	if err := c.UnmarshalKey("targets", &r.targets); err != nil {
+		return err
+	}
+	for i, target := range r.targets {
+		if target.URL == "" {
+			return fmt.Errorf("relay target %d is missing its url", i+1)
+		}
+		u, err := url.Parse(target.URL)
+		if err != nil {
+			return fmt.Errorf("relay target %d has an invalid url", i+1)
+		}
+		target.client = newRetryClient(c.GetInt("retries"))
+		// Webhook URLs often contain a token in the path or in the query
+		target.client.secrets = []string{strings.TrimPrefix(u.Path, "/"), u.RawQuery}
+		target.redacted = target.client.redact(u.Redacted())
+		target.queue = make(chan relayRequest, queueSize)
+		go target.run()
+	}
+	return nil
+}
+
+// Middleware copies every request for the relay targets before it is passed to next
+func (r *Relay) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRelayBody))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusRequestEntityTooLarge)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		for _, target := range r.targets {
+			header := http.Header{}
+			for _, name := range append([]string{"Content-Type"}, target.Headers...) {
+				if values := req.Header.Values(name); len(values) > 0 {
+					header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+			select {
+			case target.queue <- relayRequest{body: body, header: header}:
+			default:
+				log.WithFields(log.Fields{
+					"url": target.redacted,
+				}).Warn("Queue of relay target is full. Dropping request")
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	}
+}
+
+// run sends the queued requests to the target
+func (t *relayTarget) run() {
+	for relayed := range t.queue {
+		_, err := t.client.do(func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(relayed.body))
+			if err != nil {
+				return nil, err
+			}
+			req.Header = relayed.header.Clone()
+			req.Header.Set("User-Agent", "CptHook")
+			return req, nil
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"url": t.redacted,
+			}).Errorf("Failed to relay request: %s", err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"url": t.redacted,
+		}).Debug("Relayed request")
+	}
+}
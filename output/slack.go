@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// SlackSink posts messages to Slack-compatible incoming webhooks, e.g. of Slack or Mattermost
+type SlackSink struct {
+	renderer renderer
+	targets  map[string]slackTarget
+	mapping  channelMapping
+	client   *retryClient
+}
+
+// slackTarget is a single incoming webhook
+type slackTarget struct {
+	URL      string `mapstructure:"url"`
+	Channel  string `mapstructure:"channel"`
+	Username string `mapstructure:"username"`
+	IconURL  string `mapstructure:"icon_url"`
+}
+
+type slackPayload struct {
+	Text     string `json:"text"`
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+	IconURL  string `json:"icon_url,omitempty"`
+}
+
+func (s *SlackSink) Init(c *viper.Viper) error {
+	c.SetDefault("format", "mrkdwn")
+	c.SetDefault("retries", 3)
+
+	switch c.GetString("format") {
+	case "mrkdwn":
+		s.renderer = mrkdwnRenderer
+	case "markdown":
+		s.renderer = markdownRenderer
+	default:
+		return fmt.Errorf("format must be mrkdwn (Slack) or markdown (Mattermost)")
+	}
+
+	if err := c.UnmarshalKey("targets", &s.targets); err != nil {
+		return err
+	}
+	if err := c.Unmarshal(&s.mapping); err != nil {
+		return err
+	}
+	for name, target := range s.targets {
+		if target.URL == "" {
+			return fmt.Errorf("target %q is missing its url", name)
+		}
+	}
+	for _, name := range s.mapping.allTargets() {
+		if _, ok := s.targets[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("unknown target %q", name)
+		}
+	}
+
+	s.client = newRetryClient(c.GetInt("retries"))
+	// The path of a webhook URL is its secret
+	for name, target := range s.targets {
+		u, err := url.Parse(target.URL)
+		if err != nil {
+			return fmt.Errorf("target %q has an invalid url", name)
+		}
+		s.client.secrets = append(s.client.secrets, strings.TrimPrefix(u.Path, "/"))
+	}
+	return nil
+}
+
+// Send posts all lines of the message together as a single post
+func (s *SlackSink) Send(message input.IRCMessage) {
+	text := s.renderer.renderLines(message.Messages, "\n")
+
+	for _, name := range s.mapping.targets(message.Channel) {
+		// viper lowercases all keys
+		target := s.targets[strings.ToLower(name)]
+		payload, err := json.Marshal(slackPayload{
+			Text:     text,
+			Channel:  target.Channel,
+			Username: target.Username,
+			IconURL:  target.IconURL,
+		})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		_, err = s.client.do(func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(payload))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			return req, nil
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"MsgID":  message.ID,
+				"target": name,
+			}).Errorf("Failed to post message to incoming webhook: %s", err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"MsgID":  message.ID,
+			"target": name,
+		}).Debug("Posted message to incoming webhook")
+	}
+}
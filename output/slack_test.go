@@ -0,0 +1,73 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+func TestSlackSink(t *testing.T) {
+	var requests int
+	var posted []slackPayload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// The first attempt fails and has to be retried
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var p slackPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		posted = append(posted, p)
+	}))
+	defer server.Close()
+
+	config := viper.New()
+	config.Set("format", "markdown")
+	config.Set("targets", map[string]interface{}{
+		"ci-hook": map[string]interface{}{"url": server.URL + "/hooks/T000/B000/XXXX", "username": "CptHook"},
+	})
+	config.Set("channels", map[string][]string{"#ci": {"ci-hook"}})
+
+	sink := &SlackSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	sink.client.delay = time.Millisecond
+
+	sink.Send(input.IRCMessage{
+		ID:      "AB12CD",
+		Channel: "#ci",
+		Messages: []string{
+			"[\x0312my_project\x03] alice pushed 2 commits to \x0305main\x03 https://gitlab.example.com/my_project/compare/a...b",
+			"\x0315abc1234\x03 \x02Fix\x02 build",
+		},
+	})
+
+	if requests != 2 || len(posted) != 1 {
+		t.Fatalf("Slack sink made %d requests with %d posts, wanted 2 requests with 1 post", requests, len(posted))
+	}
+	want := "\\[my\\_project\\] alice pushed 2 commits to main https://gitlab.example.com/my_project/compare/a...b\nabc1234 **Fix** build"
+	if posted[0].Text != want {
+		t.Errorf("Slack sink posted wrong text: got %q wanted %q", posted[0].Text, want)
+	}
+	if posted[0].Username != "CptHook" {
+		t.Errorf("Slack sink posted wrong username: got %q wanted %q", posted[0].Username, "CptHook")
+	}
+
+	// The path of the webhook URL is a secret and must not show up in errors
+	server.Close()
+	_, err := sink.client.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, server.URL+"/hooks/T000/B000/XXXX", nil)
+	})
+	if err == nil || strings.Contains(err.Error(), "XXXX") {
+		t.Errorf("Slack sink returned error with the webhook path: %v", err)
+	}
+}
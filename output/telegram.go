@@ -0,0 +1,213 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+const (
+	// telegramMaxLength is the maximum length of a message in characters
+	telegramMaxLength = 4096
+	// Telegram allows about one message per second to a chat, 20 per minute to a group
+	// and 30 per second overall
+	telegramChatInterval   = time.Second
+	telegramGroupInterval  = 3 * time.Second
+	telegramGlobalInterval = time.Second / 30
+)
+
+// TelegramSink sends messages to Telegram chats via the Bot API
+type TelegramSink struct {
+	apiURL              string
+	token               string
+	disableNotification bool
+	mapping             channelMapping
+	client              *retryClient
+
+	chatInterval   time.Duration
+	groupInterval  time.Duration
+	globalInterval time.Duration
+	// next is the earliest time the next message can be sent to a chat, "" for all chats
+	next map[string]time.Time
+}
+
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+	DisableNotification   bool   `json:"disable_notification,omitempty"`
+}
+
+// telegramRenderer creates the HTML subset supported by Telegram, which has no colors
+var telegramRenderer = renderer{
+	escape:    strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace,
+	bold:      [2]string{"<b>", "</b>"},
+	italic:    [2]string{"<i>", "</i>"},
+	underline: [2]string{"<u>", "</u>"},
+	strike:    [2]string{"<s>", "</s>"},
+	monospace: [2]string{"<code>", "</code>"},
+}
+
+func (t *TelegramSink) Init(c *viper.Viper) error {
+	c.SetDefault("api_url", "https://api.telegram.org")
+	c.SetDefault("retries", 3)
+
+	t.apiURL = strings.TrimSuffix(c.GetString("api_url"), "/")
+	t.token = c.GetString("bot_token")
+	t.disableNotification = c.GetBool("disable_notification")
+	t.client = newRetryClient(c.GetInt("retries"))
+	// The token is part of the URL
+	t.client.secrets = []string{t.token}
+	t.chatInterval = telegramChatInterval
+	t.groupInterval = telegramGroupInterval
+	t.globalInterval = telegramGlobalInterval
+	t.next = map[string]time.Time{}
+
+	if t.token == "" {
+		return fmt.Errorf("bot_token is required")
+	}
+	return c.Unmarshal(&t.mapping)
+}
+
+// Send sends all lines of the message as a single Telegram message. Messages which are
+// too long are split at line boundaries.
+func (t *TelegramSink) Send(message input.IRCMessage) {
+	texts := t.split(message.Messages)
+chats:
+	for _, chat := range t.mapping.targets(message.Channel) {
+		for _, text := range texts {
+			if err := t.send(chat, text); err != nil {
+				log.WithFields(log.Fields{
+					"MsgID": message.ID,
+					"chat":  chat,
+				}).Errorf("Failed to send message to Telegram: %s", err)
+				continue chats
+			}
+		}
+		log.WithFields(log.Fields{
+			"MsgID": message.ID,
+			"chat":  chat,
+		}).Debug("Sent message to Telegram")
+	}
+}
+
+// split renders the lines and groups them into texts below the maximum length. Every line
+// is rendered on its own, so the markup never spans two texts.
+func (t *TelegramSink) split(lines []string) []string {
+	var texts []string
+	var current []string
+	length := 0
+	for _, line := range lines {
+		rendered := telegramRenderer.render(line)
+		if len([]rune(rendered)) > telegramMaxLength {
+			// A single line that long can't be split safely, send it without formatting.
+			// The plain text is truncated before escaping, so no entity is cut in half.
+			rendered = telegramRenderer.escape(truncatePlain(stripFormatting(line)))
+		}
+		n := len([]rune(rendered))
+		if len(current) > 0 && length+1+n > telegramMaxLength {
+			texts = append(texts, strings.Join(current, "\n"))
+			current, length = nil, 0
+		}
+		if len(current) > 0 {
+			length++
+		}
+		current = append(current, rendered)
+		length += n
+	}
+	if len(current) > 0 {
+		texts = append(texts, strings.Join(current, "\n"))
+	}
+	return texts
+}
+
+// truncatePlain shortens text, so it still fits into a message after escaping the
+// characters which grow the most, e.g. & to &amp;
+func truncatePlain(text string) string {
+	runes := []rune(text)
+	escaped := 0
+	for i, r := range runes {
+		n := 1
+		switch r {
+		case '&':
+			n = len("&amp;")
+		case '<', '>':
+			n = len("&lt;")
+		}
+		if escaped+n > telegramMaxLength-1 {
+			return string(runes[:i]) + "…"
+		}
+		escaped += n
+	}
+	return text
+}
+
+// wait sleeps until a message can be sent to the chat without hitting the rate limits.
+// Send is only called by the queue of the sink, so no locking is needed.
+func (t *TelegramSink) wait(chat string) {
+	until := t.next[""]
+	if next := t.next[chat]; next.After(until) {
+		until = next
+	}
+	time.Sleep(time.Until(until))
+}
+
+// schedule records when the next message can be sent after a message to the chat
+func (t *TelegramSink) schedule(chat string) {
+	// Group and channel IDs are negative
+	interval := t.chatInterval
+	if strings.HasPrefix(chat, "-") || strings.HasPrefix(chat, "@") {
+		interval = t.groupInterval
+	}
+	now := time.Now()
+	t.next[chat] = now.Add(interval)
+	t.next[""] = now.Add(t.globalInterval)
+}
+
+func (t *TelegramSink) send(chat, text string) error {
+	payload, err := json.Marshal(telegramMessage{
+		ChatID:                chat,
+		Text:                  text,
+		ParseMode:             "HTML",
+		DisableWebPagePreview: true,
+		DisableNotification:   t.disableNotification,
+	})
+	if err != nil {
+		return err
+	}
+
+	t.wait(chat)
+	defer t.schedule(chat)
+	response, err := t.client.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, t.apiURL+"/bot"+t.token+"/sendMessage", bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(response, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("request rejected: %s", result.Description)
+	}
+	return nil
+}
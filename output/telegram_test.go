@@ -0,0 +1,100 @@
+package output
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+func TestTelegramSink(t *testing.T) {
+	var requests int
+	var sent []telegramMessage
+	var times []time.Time
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot123:secret/sendMessage" {
+			t.Errorf("Telegram sink called wrong path %q", r.URL.Path)
+		}
+		requests++
+		if requests == 1 {
+			// The first attempt is rate limited and has to be retried
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 1", "parameters": {"retry_after": 1}}`))
+			return
+		}
+		var m telegramMessage
+		json.NewDecoder(r.Body).Decode(&m)
+		sent = append(sent, m)
+		times = append(times, time.Now())
+		w.Write([]byte(`{"ok": true, "result": {}}`))
+	}))
+	defer server.Close()
+
+	config := viper.New()
+	config.Set("api_url", server.URL+"/")
+	config.Set("bot_token", "123:secret")
+	config.Set("channels", map[string][]string{"#infra": {"-100123"}})
+
+	sink := &TelegramSink{}
+	if err := sink.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	sink.groupInterval = 100 * time.Millisecond
+
+	start := time.Now()
+	sink.Send(input.IRCMessage{ID: "AB12CD", Channel: "#infra", Messages: []string{"Host \x02web1\x02 is \x0304DOWN\x03", "\x1Dcheck_ping\x1D: <timeout> & \x1Epacket loss\x1E"}})
+	sink.Send(input.IRCMessage{ID: "EF34GH", Channel: "#infra", Messages: []string{"Host web1 is UP"}})
+
+	if len(sent) != 2 {
+		t.Fatalf("Telegram sink sent %d messages, wanted 2", len(sent))
+	}
+	if times[0].Sub(start) < time.Second {
+		t.Error("Telegram sink didn't respect retry_after")
+	}
+	if times[1].Sub(times[0]) < sink.groupInterval {
+		t.Error("Telegram sink didn't respect the rate limit of the chat")
+	}
+
+	want := "Host <b>web1</b> is DOWN\n<i>check_ping</i>: &lt;timeout&gt; &amp; <s>packet loss</s>"
+	if sent[0].Text != want || sent[0].ChatID != "-100123" || sent[0].ParseMode != "HTML" {
+		t.Errorf("Telegram sink sent wrong message: got %+v wanted text %q", sent[0], want)
+	}
+}
+
+func TestTelegramSplit(t *testing.T) {
+	sink := &TelegramSink{}
+	line := make([]byte, 3000)
+	for i := range line {
+		line[i] = 'a'
+	}
+	texts := sink.split([]string{"\x02first\x02", string(line), string(line), string(line) + string(line)})
+	if len(texts) != 3 {
+		t.Fatalf("Split into %d texts, wanted 3", len(texts))
+	}
+	for _, text := range texts {
+		if n := len([]rune(text)); n > telegramMaxLength {
+			t.Errorf("Text has %d characters", n)
+		}
+	}
+	if texts[0] != "<b>first</b>\n"+string(line) {
+		t.Errorf("First text wasn't grouped: %.40q", texts[0])
+	}
+
+	// A short line with a lot of formatting is longer than the limit once rendered
+	texts = sink.split([]string{strings.Repeat("\x02a\x02 & ", 400)})
+	if len(texts) != 1 || texts[0] != strings.Repeat("a &amp; ", 400) {
+		t.Errorf("Heavily formatted line wasn't sent as plain text: %.40q", texts)
+	}
+
+	// Entities are never cut in half when the plain text is truncated
+	texts = sink.split([]string{strings.Repeat("&", 5000)})
+	if n := len([]rune(texts[0])); len(texts) != 1 || n > telegramMaxLength || !strings.HasSuffix(texts[0], "&amp;…") {
+		t.Errorf("Long line was truncated wrong: %d characters, ends with %q", n, texts[0][len(texts[0])-10:])
+	}
+}
@@ -0,0 +1,390 @@
+package output
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+const (
+	nsStreams     = "http://etherx.jabber.org/streams"
+	nsTLS         = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL        = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind        = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsMUC         = "http://jabber.org/protocol/muc"
+	nsXHTMLIM     = "http://jabber.org/protocol/xhtml-im"
+	nsXHTML       = "http://www.w3.org/1999/xhtml"
+	xmppReady     = 30 * time.Second
+	xmppKeepAlive = 60 * time.Second
+)
+
+// XMPPSink sends messages to XMPP multi-user chat rooms
+type XMPPSink struct {
+	user      string
+	domain    string
+	password  string
+	resource  string
+	nickname  string
+	server    string
+	directTLS bool
+	xhtml     bool
+	tlsConfig *tls.Config
+	mapping   channelMapping
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// xmppFeatures are the stream features offered by the server
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// xhtmlRenderer creates XHTML-IM, which only allows a few elements and styles
+var xhtmlRenderer = renderer{
+	escape:    html.EscapeString,
+	bold:      [2]string{"<strong>", "</strong>"},
+	italic:    [2]string{"<em>", "</em>"},
+	underline: [2]string{"<span style='text-decoration: underline'>", "</span>"},
+	strike:    [2]string{"<span style='text-decoration: line-through'>", "</span>"},
+	monospace: [2]string{"<span style='font-family: monospace'>", "</span>"},
+	color: func(color string) [2]string {
+		return [2]string{"<span style='color: " + color + "'>", "</span>"}
+	},
+}
+
+func (x *XMPPSink) Init(c *viper.Viper) error {
+	if err := x.initConfig(c); err != nil {
+		return err
+	}
+	go x.run()
+	return nil
+}
+
+func (x *XMPPSink) initConfig(c *viper.Viper) error {
+	c.SetDefault("resource", "CptHook")
+	c.SetDefault("nickname", "CptHook")
+	c.SetDefault("direct_tls", false)
+	c.SetDefault("format", "xhtml")
+
+	jid := c.GetString("jid")
+	parts := strings.SplitN(jid, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("jid must look like user@example.com")
+	}
+	x.user, x.domain = parts[0], parts[1]
+	x.password = c.GetString("password")
+	x.resource = c.GetString("resource")
+	x.nickname = c.GetString("nickname")
+	x.directTLS = c.GetBool("direct_tls")
+	x.tlsConfig = &tls.Config{ServerName: x.domain}
+
+	x.server = c.GetString("server")
+	if x.server == "" {
+		x.server = x.defaultServer()
+	}
+
+	switch c.GetString("format") {
+	case "xhtml":
+		x.xhtml = true
+	case "plain":
+		x.xhtml = false
+	default:
+		return fmt.Errorf("format must be xhtml or plain")
+	}
+
+	return c.Unmarshal(&x.mapping)
+}
+
+// defaultServer looks up the server of the domain via SRV records
+func (x *XMPPSink) defaultServer() string {
+	service, port := "xmpp-client", "5222"
+	if x.directTLS {
+		service, port = "xmpps-client", "5223"
+	}
+	if _, records, err := net.LookupSRV(service, "tcp", x.domain); err == nil && len(records) > 0 {
+		return net.JoinHostPort(strings.TrimSuffix(records[0].Target, "."), fmt.Sprint(records[0].Port))
+	}
+	return net.JoinHostPort(x.domain, port)
+}
+
+func (x *XMPPSink) Send(message input.IRCMessage) {
+	rooms := x.mapping.targets(message.Channel)
+	if len(rooms) == 0 {
+		return
+	}
+
+	body := renderPlain(message.Messages)
+	var xhtml string
+	if x.xhtml {
+		xhtml = fmt.Sprintf("<html xmlns='%s'><body xmlns='%s'>%s</body></html>",
+			nsXHTMLIM, nsXHTML, xhtmlRenderer.renderLines(message.Messages, "<br/>"))
+	}
+
+	for _, room := range rooms {
+		stanza := fmt.Sprintf("<message to='%s' type='groupchat' id='%s'><body>%s</body>%s</message>",
+			xmlEscape(room), xmlEscape(message.ID), xmlEscape(body), xhtml)
+		if err := x.write(stanza); err != nil {
+			log.WithFields(log.Fields{
+				"MsgID": message.ID,
+				"room":  room,
+			}).Errorf("Failed to send message to XMPP: %s", err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"MsgID": message.ID,
+			"room":  room,
+		}).Debug("Sent message to XMPP")
+	}
+}
+
+// write sends a stanza. When we are not connected, it waits a while for the connection.
+func (x *XMPPSink) write(stanza string) error {
+	deadline := time.Now().Add(xmppReady)
+	for {
+		x.mu.Lock()
+		conn := x.conn
+		if conn != nil {
+			_, err := conn.Write([]byte(stanza))
+			x.mu.Unlock()
+			return err
+		}
+		x.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not connected to the XMPP server")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// run keeps the connection to the server up
+func (x *XMPPSink) run() {
+	delay := 5 * time.Second
+	for {
+		conn, decoder, err := x.connect()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"server": x.server,
+			}).Errorf("Failed to connect to XMPP server: %s", err)
+			time.Sleep(delay)
+			delay = min(delay*2, 5*time.Minute)
+			continue
+		}
+		delay = 5 * time.Second
+
+		for _, room := range x.mapping.allTargets() {
+			presence := fmt.Sprintf("<presence to='%s/%s'><x xmlns='%s'><history maxstanzas='0'/></x></presence>",
+				xmlEscape(room), xmlEscape(x.nickname), nsMUC)
+			conn.Write([]byte(presence))
+		}
+
+		x.mu.Lock()
+		x.conn = conn
+		x.mu.Unlock()
+		log.WithFields(log.Fields{
+			"server": x.server,
+		}).Info("Connected to XMPP server")
+
+		stop := make(chan struct{})
+		go x.keepAlive(conn, stop)
+
+		// Everything the server sends us is ignored, we only notice when the stream ends
+		for {
+			if _, err = decoder.Token(); err != nil {
+				break
+			}
+		}
+		close(stop)
+
+		x.mu.Lock()
+		x.conn = nil
+		x.mu.Unlock()
+		conn.Close()
+		log.Warnf("Lost connection to XMPP server: %s", err)
+	}
+}
+
+// keepAlive sends whitespace regularly, so idle connections aren't closed
+func (x *XMPPSink) keepAlive(conn net.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(xmppKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			x.mu.Lock()
+			_, err := conn.Write([]byte(" "))
+			x.mu.Unlock()
+			if err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// connect opens the stream, enables TLS, authenticates and binds a resource
+func (x *XMPPSink) connect() (net.Conn, *xml.Decoder, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if x.directTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", x.server, x.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", x.server)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	decoder, features, err := x.openStream(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if !x.directTLS {
+		if features.StartTLS == nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("server doesn't offer STARTTLS")
+		}
+		conn.Write([]byte("<starttls xmlns='" + nsTLS + "'/>"))
+		if el, err := nextElement(decoder); err != nil || el.Name.Local != "proceed" {
+			conn.Close()
+			return nil, nil, fmt.Errorf("STARTTLS failed")
+		}
+		tlsConn := tls.Client(conn, x.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+		if decoder, features, err = x.openStream(conn); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	if err := x.authenticate(conn, decoder, features); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if decoder, features, err = x.openStream(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if features.Bind == nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("server doesn't offer resource binding")
+	}
+	conn.Write([]byte(fmt.Sprintf("<iq type='set' id='bind'><bind xmlns='%s'><resource>%s</resource></bind></iq>",
+		nsBind, xmlEscape(x.resource))))
+	el, err := nextElement(decoder)
+	if err != nil || el.Name.Local != "iq" || attribute(el, "type") != "result" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("resource binding failed")
+	}
+	decoder.Skip()
+
+	conn.SetDeadline(time.Time{})
+	return conn, decoder, nil
+}
+
+// authenticate logs in with SASL PLAIN. The connection is always encrypted at this point.
+func (x *XMPPSink) authenticate(conn net.Conn, decoder *xml.Decoder, features xmppFeatures) error {
+	plain := false
+	for _, m := range features.Mechanisms {
+		if m == "PLAIN" {
+			plain = true
+		}
+	}
+	if !plain {
+		return fmt.Errorf("server doesn't offer SASL PLAIN")
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + x.user + "\x00" + x.password))
+	conn.Write([]byte("<auth xmlns='" + nsSASL + "' mechanism='PLAIN'>" + credentials + "</auth>"))
+	el, err := nextElement(decoder)
+	if err != nil {
+		return err
+	}
+	if el.Name.Local != "success" {
+		return fmt.Errorf("authentication failed")
+	}
+	return decoder.Skip()
+}
+
+// openStream starts a new stream and returns the features of the server
+func (x *XMPPSink) openStream(conn net.Conn) (*xml.Decoder, xmppFeatures, error) {
+	var features xmppFeatures
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='%s' version='1.0'>",
+		xmlEscape(x.domain), nsStreams)
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return nil, features, err
+	}
+
+	decoder := xml.NewDecoder(conn)
+	el, err := nextElement(decoder)
+	if err != nil {
+		return nil, features, err
+	}
+	if el.Name.Space != nsStreams || el.Name.Local != "stream" {
+		return nil, features, fmt.Errorf("unexpected element %q instead of stream", el.Name.Local)
+	}
+
+	el, err = nextElement(decoder)
+	if err != nil {
+		return nil, features, err
+	}
+	if el.Name.Space != nsStreams || el.Name.Local != "features" {
+		return nil, features, fmt.Errorf("unexpected element %q instead of stream features", el.Name.Local)
+	}
+	err = decoder.DecodeElement(&features, &el)
+	return decoder, features, err
+}
+
+// nextElement returns the next start element and skips everything else
+func nextElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if el, ok := token.(xml.StartElement); ok {
+			return el, nil
+		}
+	}
+}
+
+func attribute(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func xmlEscape(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
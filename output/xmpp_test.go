@@ -0,0 +1,143 @@
+package output
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleaz/CptHook/input"
+	"github.com/spf13/viper"
+)
+
+// testCertificate creates a self-signed certificate for example.com
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// fakeXMPPServer accepts a single client with direct TLS and passes the stanzas it
+// receives after the login to stanzas
+func fakeXMPPServer(t *testing.T, listener net.Listener, stanzas chan string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	header := "<?xml version='1.0'?><stream:stream from='example.com' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"
+	decoder := xml.NewDecoder(conn)
+	expectStream := func() {
+		if el, err := nextElement(decoder); err != nil || el.Name.Local != "stream" {
+			t.Errorf("Client didn't open a stream: %v", err)
+		}
+	}
+
+	expectStream()
+	conn.Write([]byte(header + "<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>"))
+
+	var auth struct {
+		Mechanism string `xml:"mechanism,attr"`
+		Value     string `xml:",chardata"`
+	}
+	el, _ := nextElement(decoder)
+	decoder.DecodeElement(&auth, &el)
+	if auth.Mechanism != "PLAIN" || auth.Value != "AGJvdABzZWNyZXQ=" {
+		t.Errorf("Client sent wrong credentials: %+v", auth)
+	}
+	conn.Write([]byte("<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>"))
+
+	decoder = xml.NewDecoder(conn)
+	expectStream()
+	conn.Write([]byte(header + "<stream:features><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/></stream:features>"))
+	el, _ = nextElement(decoder)
+	decoder.Skip()
+	conn.Write([]byte("<iq type='result' id='" + attribute(el, "id") + "'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><jid>bot@example.com/CptHook</jid></bind></iq>"))
+
+	for {
+		el, err := nextElement(decoder)
+		if err != nil {
+			return
+		}
+		var stanza struct {
+			To   string `xml:"to,attr"`
+			Body string `xml:"body"`
+			HTML struct {
+				Inner string `xml:",innerxml"`
+			} `xml:"html"`
+		}
+		decoder.DecodeElement(&stanza, &el)
+		stanzas <- el.Name.Local + " " + stanza.To + " " + stanza.Body + " " + stanza.HTML.Inner
+	}
+}
+
+func TestXMPPSink(t *testing.T) {
+	cert, pool := testCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	stanzas := make(chan string, 10)
+	go fakeXMPPServer(t, listener, stanzas)
+
+	config := viper.New()
+	config.Set("jid", "bot@example.com")
+	config.Set("password", "secret")
+	config.Set("server", listener.Addr().String())
+	config.Set("direct_tls", true)
+	config.Set("channels", map[string][]string{"#infra": {"infra@conference.example.com"}})
+
+	sink := &XMPPSink{}
+	if err := sink.initConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	sink.tlsConfig.RootCAs = pool
+	go sink.run()
+
+	sink.Send(input.IRCMessage{ID: "AB12CD", Channel: "#infra", Messages: []string{"Host \x02web1\x02 is DOWN", "→ <timeout>"}})
+
+	wanted := []string{
+		"presence infra@conference.example.com/CptHook  ",
+		"message infra@conference.example.com Host web1 is DOWN\n→ <timeout> <body xmlns='http://www.w3.org/1999/xhtml'>Host <strong>web1</strong> is DOWN<br/>→ &lt;timeout&gt;</body>",
+	}
+	for _, want := range wanted {
+		select {
+		case got := <-stanzas:
+			if !strings.HasPrefix(got, want) {
+				t.Errorf("XMPP server received wrong stanza: got %q wanted %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("XMPP server didn't receive %q", want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows one action per key within the configured interval
+type rateLimiter struct {
+	interval time.Duration
+
+	mu    sync.Mutex
+	last  map[string]time.Time
+	swept time.Time
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		last:     map[string]time.Time{},
+	}
+}
+
+// Allow reports if the action for key is allowed and records it
+func (r *rateLimiter) Allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := r.last[key]; ok && now.Sub(last) < r.interval {
+		return false
+	}
+	r.last[key] = now
+
+	// Forget old entries once per interval, so the map doesn't grow forever
+	if now.Sub(r.swept) >= r.interval {
+		for k, t := range r.last {
+			if now.Sub(t) >= r.interval {
+				delete(r.last, k)
+			}
+		}
+		r.swept = now
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(time.Hour)
+	if !r.Allow("alice") || !r.Allow("bob") {
+		t.Fatal("First actions weren't allowed")
+	}
+	if r.Allow("alice") {
+		t.Error("Second action within the interval was allowed")
+	}
+
+	// Old entries are only removed once per interval
+	r.last["bob"] = time.Now().Add(-2 * time.Hour)
+	r.Allow("carol")
+	if _, ok := r.last["bob"]; !ok {
+		t.Error("Entries were removed before the interval passed")
+	}
+	r.swept = time.Now().Add(-2 * time.Hour)
+	r.Allow("dave")
+	if _, ok := r.last["bob"]; ok {
+		t.Error("Old entry wasn't removed")
+	}
+	if r.Allow("alice") {
+		t.Error("Recent entry was removed")
+	}
+}